@@ -0,0 +1,543 @@
+package httplb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// picker 负载均衡策略的选取算法
+//
+// 服务发现、HTTP Client的生命周期、健康检查、离群检测及熔断均由balancer负责，
+// 各策略只需根据可用节点的快照选取节点
+type picker interface {
+	// update 可用节点变化时调用，此时cs尚未发布，可在cs上初始化策略所需的节点状态。
+	// 调用时持有balancer的写锁
+	update(cs []*lbClient, config *Config)
+	// choose 从可用节点cs中选取发送req的节点，Get()时req为nil。
+	// 可并发调用，不能修改cs
+	choose(cs []*lbClient, req *fasthttp.Request) *lbClient
+}
+
+// balancer 各策略负载均衡器共用的部分
+//
+// 负责通过服务发现来源获取节点、创建及关闭HTTP Client、健康检查、离群检测及熔断，
+// 可用节点变化时通知picker并发布新的快照，选取节点时无需加锁。
+// 配置了Config.Hedge时，幂等请求在延迟时间内未返回则向另一个节点发送对冲请求；
+// 否则配置了Config.Retry时，请求失败后在其他节点上重试。
+// 各策略负载均衡器内嵌*balancer并实现picker，切换负载均衡策略时新策略的负载均衡器沿用同一个balancer，只替换picker
+//
+// It is forbidden copying balancer instances. Create new instances instead.
+//
+// It is safe calling balancer methods from concurrently running goroutines.
+type balancer struct {
+
+	// clients must contain non-zero clients list.
+	// Incoming requests are balanced among these clients.
+	clients []Client
+	config  *Config // 记录配置文件
+
+	// HealthCheck is a callback called after each request.
+	//
+	// The request, response and the error returned by the client
+	// is passed to HealthCheck, so the callback may determine whether
+	// the client is healthy.
+	//
+	// Load on the current client is decreased if HealthCheck returns false.
+	//
+	// By default HealthCheck returns false if err != nil.
+	//
+	// Config.HealthCheckFunc is used if HealthCheck is not set.
+	HealthCheck func(req *fasthttp.Request, resp *fasthttp.Response, err error) bool
+
+	// HealthCheckContext is the same as HealthCheck, but also receives
+	// the ctx passed to DoContext, so tracing and deadlines carry through.
+	// context.Background() is passed for requests sent without a context.
+	//
+	// HealthCheckContext takes precedence over HealthCheck if set.
+	// Config.HealthCheckContextFunc is used if neither is set.
+	HealthCheckContext func(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, err error) bool
+
+	// Timeout is the request timeout used when calling Do.
+	//
+	// Opts.RequestTimeout takes precedence if set.
+	// DefaultLBClientTimeout is used by default.
+	Timeout time.Duration
+
+	picker  picker               // 当前的选取算法，随可选取的节点一同发布
+	cs      clientList           // 可选取的节点，选取时无需加锁
+	records map[Client]*lbClient // 各HTTP Client的负载统计及节点状态，节点列表变化时保留已有的记录
+
+	watcher  *watcher
+	outlier  *outlierDetector
+	breakers *circuitBreakers
+	budgets  *requestBudgets // 重试及对冲请求的预算
+
+	lock       sync.RWMutex
+	reloadLock sync.Mutex // 串行化UpdateConfig
+
+	lifecycle
+}
+
+// newBalancer 创建并启动以p选取节点的balancer
+//
+// core为切换负载均衡策略前的balancer时直接返回core，新策略的负载均衡器沿用其watcher、HTTP Clients
+// 及各节点的负载统计、健康、移出、熔断状态，由dynamicLB.UpdateConfig通过updateConfig替换picker
+func newBalancer(p picker, config *Config, core *balancer) *balancer {
+	if core != nil {
+		return core
+	}
+	b := &balancer{}
+	b.start(p, config)
+	return b
+}
+
+// start 等待服务发现来源推送第一次的节点列表，并启动watch、健康检查、离群检测及熔断协程
+func (b *balancer) start(p picker, config *Config) {
+	b.clients = createClients(config.NodeList, config.Opts)
+	b.picker = p
+	b.watcher = newWatcher(config)
+	b.config = config
+	b.lifecycle = newLifecycle()
+	b.outlier = newOutlierDetector(b, b.done)
+	b.breakers = newCircuitBreakers(b, b.done)
+	b.budgets = &requestBudgets{}
+	b.fetchOnce()
+	w := b.watcher
+	b.goWatch(func() { b.watch(w) })
+	b.goWatch(newHealthChecker(b, b.done).run)
+	b.goWatch(b.outlier.run)
+	b.goWatch(b.breakers.run)
+}
+
+// watch 接收服务发现来源w推送的节点列表，w被UpdateConfig替换或关闭后退出
+//
+// 配置了Config.Discovery时合并Debounce内连续推送的节点列表，只应用最后一次
+func (b *balancer) watch(w *watcher) {
+	var (
+		pending []*Node   // 等待应用的节点列表
+		since   time.Time // 开始等待的时间
+		timer   *time.Timer
+		fire    <-chan time.Time
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-fire:
+			fire = nil
+			if !b.apply(w, pending) {
+				return
+			}
+			pending = nil
+		case nodes, ok := <-w.Updates():
+			if !ok {
+				if pending != nil {
+					b.apply(w, pending)
+				}
+				return
+			}
+			if len(nodes) == 0 {
+				continue
+			}
+			delay := b.debounce(since, pending != nil)
+			if delay <= 0 {
+				fire, pending = nil, nil
+				if !b.apply(w, nodes) {
+					return
+				}
+				continue
+			}
+			if pending == nil {
+				since = time.Now()
+			}
+			pending = nodes
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.NewTimer(delay)
+			fire = timer.C
+		}
+	}
+}
+
+// debounce 计算收到节点列表后需等待的时间，waiting表示自since起已在等待
+func (b *balancer) debounce(since time.Time, waiting bool) time.Duration {
+	b.lock.RLock()
+	dc := b.config.Discovery
+	b.lock.RUnlock()
+	if dc == nil || dc.Debounce <= 0 {
+		return 0
+	}
+	if !waiting {
+		return dc.Debounce
+	}
+	// 连续变化时不超过MaxDebounce
+	delay := dc.Debounce
+	if left := dc.MaxDebounce - time.Since(since); left < delay {
+		delay = left
+	}
+	return delay
+}
+
+// apply 应用服务发现来源w推送的节点列表，w已被更换时返回false
+func (b *balancer) apply(w *watcher, nodes []*Node) bool {
+	b.lock.Lock()
+	if w != b.watcher {
+		// 服务发现来源已通过UpdateConfig更换
+		b.lock.Unlock()
+		return false
+	}
+	oldClients := b.clients
+	newClients, isUpdate := updateClients(oldClients, nodes, b.config.Opts)
+	if isUpdate {
+		b.clients = newClients
+		b.init()
+	}
+	b.lock.Unlock()
+	if isUpdate {
+		closeRemovedClients(oldClients, newClients)
+	}
+	return true
+}
+
+// fetchOnce 等待服务发现来源推送第一次非空的节点列表，最长等待Discovery.FetchTimeout
+//
+// 超时或服务发现来源已关闭时使用配置中的节点列表，没有节点列表时请求返回ErrNoNodes，
+// 之后推送的节点列表由watch协程应用
+func (b *balancer) fetchOnce() {
+	nodes, err := waitNodes(b.watcher, b.config, b.done)
+	if err != nil {
+		logf("%v, using %d nodes from config", err, len(b.clients))
+	} else {
+		b.clients, _ = updateClients(b.clients, nodes, b.config.Opts)
+	}
+	b.lock.Lock()
+	b.init()
+	b.lock.Unlock()
+}
+
+// DoDeadline calls DoDeadline on the selected client
+func (b *balancer) DoDeadline(req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
+	if b.isClosed() {
+		return ErrLBClosed
+	}
+	return b.doDeadline(b.currentConfig(), req, resp, deadline)
+}
+
+// doDeadline 按配置发送对冲请求或在失败后重试，都未配置时直接在选取的节点上发送
+func (b *balancer) doDeadline(config *Config, req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
+	switch {
+	case config.Hedge != nil && isIdempotent(req):
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		return timeoutError(doHedged(b, config.Hedge, ctx, req, resp))
+	case config.Retry != nil:
+		return doWithRetry(b, config.Retry, context.Background(), req, resp, func(c *lbClient) error {
+			return c.DoDeadline(req, resp, deadline)
+		})
+	default:
+		return sendPicked(req, b.pick, func(c *lbClient) error {
+			return c.DoDeadline(req, resp, deadline)
+		})
+	}
+}
+
+// DoTimeout calculates deadline and calls DoDeadline on the selected client
+func (b *balancer) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	if b.isClosed() {
+		return ErrLBClosed
+	}
+	config := b.currentConfig()
+	if config.Hedge == nil && config.Retry == nil {
+		return sendPicked(req, b.pick, func(c *lbClient) error {
+			return c.DoTimeout(req, resp, timeout)
+		})
+	}
+	return b.doDeadline(config, req, resp, time.Now().Add(timeout))
+}
+
+// Do calls calculates deadline using Timeout and calls DoDeadline
+// on the selected client.
+//
+// The timeout may be overridden per request via TimeoutHeader.
+// ErrSelectionTimeout or ErrUpstreamTimeout is returned on timeout.
+func (b *balancer) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	if b.isClosed() {
+		return ErrLBClosed
+	}
+	config := b.currentConfig()
+	if config.Hedge == nil && config.Retry == nil {
+		return doTimeout(req, resp, b.requestTimeout, b.pick)
+	}
+	timeout, ok := headerTimeout(req)
+	if !ok {
+		timeout = b.requestTimeout()
+	}
+	deadline := time.Now().Add(timeout)
+	if !time.Now().Before(deadline) {
+		return ErrSelectionTimeout
+	}
+	return upstreamTimeout(b.doDeadline(config, req, resp, deadline))
+}
+
+// DoContext calls DoContext on the selected client, so ctx deadline
+// and cancellation apply to the whole request.
+//
+// If ctx has no deadline, the timeout used by Do applies. The timeout may be
+// overridden per request via WithRequestTimeout or TimeoutHeader.
+func (b *balancer) DoContext(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error {
+	if b.isClosed() {
+		return ErrLBClosed
+	}
+	config := b.currentConfig()
+	if config.Hedge == nil && config.Retry == nil {
+		return doContextTimeout(ctx, req, resp, b.requestTimeout, b.pick)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	reqCtx, cancel, applied := withRequestTimeout(ctx, req, b.requestTimeout)
+	defer cancel()
+	var err error
+	switch {
+	case config.Hedge != nil && isIdempotent(req):
+		err = doHedged(b, config.Hedge, reqCtx, req, resp)
+	case config.Retry != nil:
+		err = doWithRetry(b, config.Retry, reqCtx, req, resp, func(c *lbClient) error {
+			return c.DoContext(reqCtx, req, resp)
+		})
+	default:
+		err = sendPicked(req, b.pick, func(c *lbClient) error {
+			return c.DoContext(reqCtx, req, resp)
+		})
+	}
+	if applied {
+		err = contextTimeout(ctx, true, err)
+	}
+	return err
+}
+
+// sendPicked 在pick选取的节点上发送请求，节点的Client已关闭（节点已被服务发现移除）时重新选取，最多maxPickAttempts次
+//
+// Client关闭时请求未发出，因此非幂等请求同样可以重新发送
+func sendPicked(req *fasthttp.Request, pick func(req *fasthttp.Request) *lbClient, send func(c *lbClient) error) error {
+	var err error
+	for i := 0; i < maxPickAttempts; i++ {
+		c := pick(req)
+		if c == nil {
+			return ErrNoNodes
+		}
+		if err = send(c); err != errClientClosed {
+			return err
+		}
+	}
+	return err
+}
+
+// timeoutError 将请求截止时间到达时的ctx错误转换为与fasthttp一致的ErrTimeout
+func timeoutError(err error) error {
+	if err == context.DeadlineExceeded {
+		return fasthttp.ErrTimeout
+	}
+	return err
+}
+
+// requestTimeout 获取Do的默认超时时间
+func (b *balancer) requestTimeout() time.Duration {
+	b.lock.RLock()
+	opts := b.config.Opts
+	b.lock.RUnlock()
+	return requestTimeout(opts, b.Timeout)
+}
+
+// pick 选取发送req的节点，选中的节点熔断时重新选取，没有节点时返回nil
+func (b *balancer) pick(req *fasthttp.Request) *lbClient {
+	s := b.cs.snapshot()
+	if s == nil || len(s.cs) == 0 {
+		return nil
+	}
+	return pickReady(func() *lbClient {
+		return s.picker.choose(s.cs, req)
+	})
+}
+
+// Get 选取一个节点，服务发现尚未返回任何节点时返回nil
+func (b *balancer) Get() Client {
+	if c := b.pick(nil); c != nil {
+		return c
+	}
+	return nil
+}
+
+// Close 停止watch协程，取消进行中的节点查询，并关闭所有HTTP Client
+func (b *balancer) Close() error {
+	if !b.shutdown() {
+		return nil
+	}
+	b.lock.RLock()
+	w := b.watcher
+	b.lock.RUnlock()
+	w.close()
+	b.wg.Wait()
+
+	closeClients(b.currentClients())
+	return nil
+}
+
+// UpdateConfig 校验并应用新的配置，原子替换服务发现来源（static/dns/consul）及Opts
+//
+// 更换服务发现来源时最多等待Discovery.FetchTimeout获取节点，超时或期间调用Close时返回错误，原配置保持不变。
+// 仅在Opts变化时重建HTTP Client，被替换的Client等待进行中的请求完成后关闭。
+// LBStrategy的切换由New()返回的负载均衡器处理，此处忽略
+func (b *balancer) UpdateConfig(config *Config) error {
+	return b.updateConfig(config, nil)
+}
+
+// updateConfig 应用新的配置，p不为nil时同时将选取算法替换为p，p在新的节点快照发布前完成更新
+func (b *balancer) updateConfig(config *Config, p picker) error {
+	b.reloadLock.Lock()
+	defer b.reloadLock.Unlock()
+	if b.isClosed() {
+		return ErrLBClosed
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	b.lock.RLock()
+	w, oldConfig, oldClients := b.watcher, b.config, b.clients
+	b.lock.RUnlock()
+	config.inheritResources(oldConfig)
+
+	var nodes []*Node
+	if sameDiscovery(oldConfig, config) {
+		nodes = clientNodes(oldClients)
+	} else {
+		var err error
+		w = newWatcher(config)
+		if nodes, err = fetchNodes(w, config, b.done); err != nil {
+			return err
+		}
+	}
+
+	b.lock.Lock()
+	if b.isClosed() {
+		b.lock.Unlock()
+		if w != b.watcher {
+			w.close()
+		}
+		return ErrLBClosed
+	}
+	oldWatcher := b.watcher
+	oldClients = b.clients
+	newClients := reloadClients(oldClients, nodes, oldConfig.Opts, config.Opts)
+	b.watcher = w
+	b.config = config
+	b.clients = newClients
+	if p != nil {
+		b.picker = p
+	}
+	b.init()
+	if oldWatcher != w {
+		b.goWatch(func() { b.watch(w) })
+	}
+	b.lock.Unlock()
+
+	if oldWatcher != w {
+		oldWatcher.close()
+	}
+	closeRemovedClients(oldClients, newClients)
+	return nil
+}
+
+// Stats 获取各节点的负载统计
+func (b *balancer) Stats() []NodeStats {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return clientStats(b.cs.load(), b.recordsOf(b.clients))
+}
+
+func (b *balancer) currentConfig() *Config {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.config
+}
+
+// requestBudgets 获取重试及对冲请求的预算
+func (b *balancer) requestBudgets() *requestBudgets {
+	return b.budgets
+}
+
+// core 获取各策略负载均衡器共用的balancer，切换负载均衡策略时由新策略的负载均衡器沿用
+func (b *balancer) core() *balancer {
+	return b
+}
+
+func (b *balancer) currentClients() []Client {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.clients
+}
+
+// currentRecords 获取当前各HTTP Client的记录
+func (b *balancer) currentRecords() []*lbClient {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.recordsOf(b.clients)
+}
+
+// recordsOf 获取clients对应的记录，调用时持有b.lock
+func (b *balancer) recordsOf(clients []Client) []*lbClient {
+	records := make([]*lbClient, 0, len(clients))
+	for _, c := range clients {
+		if r := b.records[c]; r != nil {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// refresh 节点健康状态变化时重新选取可用的节点
+func (b *balancer) refresh() {
+	b.lock.Lock()
+	b.init()
+	b.lock.Unlock()
+}
+
+// init 根据当前的HTTP Clients及节点状态创建可用节点的快照，由picker更新后发布
+//
+// 已有Client的记录被复用，负载统计及节点状态不因节点列表或节点状态的变化而重置
+func (b *balancer) init() {
+	b.outlier.update(b.config.OutlierDetection, b.clients)
+	b.breakers.update(b.config.CircuitBreaker, b.clients)
+	records := make(map[Client]*lbClient, len(b.clients))
+	all := make([]*lbClient, 0, len(b.clients))
+	for _, c := range b.clients {
+		r := b.records[c]
+		if r == nil {
+			r = &lbClient{
+				c:       c,
+				lb:      b,
+				outlier: b.outlier.state(c),
+				breaker: b.breakers.get(c),
+			}
+		}
+		records[c] = r
+		all = append(all, r)
+	}
+	b.records = records
+	cs := availableClients(all)
+	if len(cs) > 0 {
+		// 还没有节点时不通知picker，pick直接返回nil
+		b.picker.update(cs, b.config)
+	}
+	b.cs.store(cs, b.picker, b.config)
+}
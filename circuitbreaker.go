@@ -0,0 +1,360 @@
+package httplb
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCircuitOpen 节点的断路器处于打开状态，或半开状态下探测请求数已满时返回该错误
+var ErrCircuitOpen = errors.New("httplb: circuit breaker is open")
+
+// CircuitState 断路器状态
+type CircuitState int32
+
+const (
+	CircuitClosed   CircuitState = iota // 关闭，请求正常通过
+	CircuitOpen                         // 打开，拒绝所有请求，节点不参与选择
+	CircuitHalfOpen                     // 半开，允许有限的探测请求通过
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	defaultCircuitWindow      = 10 * time.Second
+	defaultCircuitOpenTimeout = 5 * time.Second
+
+	// circuitBuckets 滚动窗口的分段数
+	circuitBuckets = 10
+
+	// maxPickAttempts 选取节点时跳过断路器未就绪节点的最多次数
+	maxPickAttempts = 3
+)
+
+// circuitBreakers 负载均衡器中各节点的断路器
+//
+// 断路器随节点保留，节点列表变化时不会重置状态。断路器打开或进入半开状态时通知负载均衡器重新选取可用节点
+type circuitBreakers struct {
+	target healthCheckTarget
+	done   <-chan struct{}
+	kick   chan struct{}
+
+	config atomic.Value // circuitConfigHolder
+
+	lock     sync.Mutex
+	breakers map[Client]*circuitBreaker
+}
+
+// circuitConfigHolder atomic.Value不能存储nil，因此包装一层
+type circuitConfigHolder struct {
+	config *CircuitBreakerConfig
+}
+
+func newCircuitBreakers(target healthCheckTarget, done <-chan struct{}) *circuitBreakers {
+	cb := &circuitBreakers{
+		target:   target,
+		done:     done,
+		kick:     make(chan struct{}, 1),
+		breakers: make(map[Client]*circuitBreaker),
+	}
+	cb.config.Store(circuitConfigHolder{})
+	return cb
+}
+
+func (cb *circuitBreakers) currentConfig() *CircuitBreakerConfig {
+	return cb.config.Load().(circuitConfigHolder).config
+}
+
+// update 负载均衡器初始化或更新时设置断路器配置，并移除已不存在的节点的断路器。
+// config为nil时关闭所有断路器
+func (cb *circuitBreakers) update(config *CircuitBreakerConfig, clients []Client) {
+	cb.config.Store(circuitConfigHolder{config})
+
+	cb.lock.Lock()
+	breakers := make(map[Client]*circuitBreaker, len(clients))
+	for _, c := range clients {
+		if b := cb.breakers[c]; b != nil {
+			breakers[c] = b
+		}
+	}
+	cb.breakers = breakers
+	cb.lock.Unlock()
+
+	if config == nil {
+		for _, b := range breakers {
+			b.reset()
+		}
+	}
+}
+
+// get 获取Client对应的断路器，不存在时创建
+func (cb *circuitBreakers) get(c Client) *circuitBreaker {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	b := cb.breakers[c]
+	if b == nil {
+		b = &circuitBreaker{owner: cb, node: c.Node()}
+		cb.breakers[c] = b
+	}
+	return b
+}
+
+func (cb *circuitBreakers) run() {
+	for {
+		select {
+		case <-cb.done:
+			return
+		case <-cb.kick:
+			cb.target.refresh()
+		}
+	}
+}
+
+func (cb *circuitBreakers) notify() {
+	select {
+	case cb.kick <- struct{}{}:
+	default:
+	}
+}
+
+// circuitBreaker 单个节点的断路器
+//
+// 关闭状态下，滚动窗口内连续失败ConsecutiveFailures次，或请求数不少于MinRequests且错误率达到ErrorRate时打开；
+// 打开OpenTimeout后进入半开状态，最多允许HalfOpenMaxRequests个探测请求，
+// 探测请求全部成功后关闭，任一失败则重新打开。请求是否失败由HealthCheck回调判断
+type circuitBreaker struct {
+	owner *circuitBreakers
+	node  *Node
+
+	lock        sync.Mutex
+	state       CircuitState // 由lock保护，选择节点及发送请求时通过published无锁读取
+	published   int32        // state的副本，关闭状态下ready及acquire只读取该副本，不加锁
+	generation  uint64       // 每次状态变化时递增，用于丢弃过期的半开定时器
+	consecutive int          // 连续失败次数
+	buckets     [circuitBuckets]circuitBucket
+	inflight    int // 半开状态下进行中的探测请求数
+	successes   int // 半开状态下成功的探测请求数
+}
+
+// circuitBucket 滚动窗口中一个分段内的请求结果
+type circuitBucket struct {
+	epoch    int64 // 分段序号，序号不是当前窗口内的分段已过期
+	success  int
+	failures int
+}
+
+// ready 判断节点当前能否接收请求，用于选择节点
+func (b *circuitBreaker) ready() bool {
+	if b.owner.currentConfig() == nil {
+		return true
+	}
+	switch b.currentState() {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return b.inflight < b.owner.currentConfig().HalfOpenMaxRequests
+	default:
+		return true
+	}
+}
+
+// acquire 请求发送前调用，返回false时拒绝该请求
+//
+// 关闭状态下不加锁直接放行；与状态变化并发时可能多放行一个请求，其结果按记录时的状态处理
+func (b *circuitBreaker) acquire() bool {
+	config := b.owner.currentConfig()
+	if config == nil {
+		return true
+	}
+	switch b.currentState() {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		return false
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if b.inflight >= config.HalfOpenMaxRequests {
+			return false
+		}
+		b.inflight++
+	}
+	return true
+}
+
+// release 请求被调用方取消时调用，只归还半开状态下占用的请求数，不计入结果
+func (b *circuitBreaker) release() {
+	if b.owner.currentConfig() == nil {
+		return
+	}
+	b.lock.Lock()
+	if b.state == CircuitHalfOpen && b.inflight > 0 {
+		b.inflight--
+	}
+	b.lock.Unlock()
+}
+
+// record 请求完成后记录结果，healthy为HealthCheck回调的判断结果
+func (b *circuitBreaker) record(healthy bool) {
+	config := b.owner.currentConfig()
+	if config == nil {
+		return
+	}
+	b.lock.Lock()
+	var from, to CircuitState
+	changed := false
+	switch b.state {
+	case CircuitClosed:
+		bucket := b.bucket(config, time.Now())
+		if healthy {
+			b.consecutive = 0
+			bucket.success++
+			break
+		}
+		b.consecutive++
+		bucket.failures++
+		if b.consecutive >= config.ConsecutiveFailures || b.errorRateExceeded(config, time.Now()) {
+			from, to, changed = b.state, CircuitOpen, true
+			b.open(config)
+		}
+	case CircuitHalfOpen:
+		if b.inflight > 0 {
+			b.inflight--
+		}
+		if !healthy {
+			from, to, changed = b.state, CircuitOpen, true
+			b.open(config)
+			break
+		}
+		b.successes++
+		if b.successes >= config.HalfOpenMaxRequests {
+			from, to, changed = b.state, CircuitClosed, true
+			b.close()
+		}
+	}
+	b.lock.Unlock()
+
+	if changed {
+		b.changed(config, from, to)
+	}
+}
+
+// bucket 获取当前时间所在的分段，已过期的分段重新计数
+func (b *circuitBreaker) bucket(config *CircuitBreakerConfig, now time.Time) *circuitBucket {
+	epoch := now.UnixNano() / int64(config.Window/circuitBuckets)
+	bucket := &b.buckets[epoch%circuitBuckets]
+	if bucket.epoch != epoch {
+		*bucket = circuitBucket{epoch: epoch}
+	}
+	return bucket
+}
+
+// errorRateExceeded 判断滚动窗口内的错误率是否达到ErrorRate
+func (b *circuitBreaker) errorRateExceeded(config *CircuitBreakerConfig, now time.Time) bool {
+	epoch := now.UnixNano() / int64(config.Window/circuitBuckets)
+	var total, failures int
+	for _, bucket := range b.buckets {
+		if epoch-bucket.epoch < circuitBuckets {
+			total += bucket.success + bucket.failures
+			failures += bucket.failures
+		}
+	}
+	return total >= config.MinRequests && float64(failures) >= config.ErrorRate*float64(total)
+}
+
+// open 打开断路器，OpenTimeout后进入半开状态
+func (b *circuitBreaker) open(config *CircuitBreakerConfig) {
+	b.state = CircuitOpen
+	b.generation++
+	b.inflight = 0
+	b.successes = 0
+	b.publish()
+
+	generation := b.generation
+	time.AfterFunc(config.OpenTimeout, func() {
+		b.lock.Lock()
+		if b.generation != generation || b.state != CircuitOpen {
+			b.lock.Unlock()
+			return
+		}
+		b.state = CircuitHalfOpen
+		b.generation++
+		b.publish()
+		b.lock.Unlock()
+
+		b.changed(b.owner.currentConfig(), CircuitOpen, CircuitHalfOpen)
+	})
+}
+
+// close 关闭断路器并清空滚动窗口
+func (b *circuitBreaker) close() {
+	b.state = CircuitClosed
+	b.generation++
+	b.consecutive = 0
+	b.inflight = 0
+	b.successes = 0
+	b.buckets = [circuitBuckets]circuitBucket{}
+	b.publish()
+}
+
+// publish 状态变化后更新无锁读取的副本，调用时持有b.lock
+func (b *circuitBreaker) publish() {
+	atomic.StoreInt32(&b.published, int32(b.state))
+}
+
+// currentState 获取断路器状态，未配置断路器时始终为CircuitClosed
+func (b *circuitBreaker) currentState() CircuitState {
+	return CircuitState(atomic.LoadInt32(&b.published))
+}
+
+// reset 关闭断路器配置后恢复为关闭状态
+func (b *circuitBreaker) reset() {
+	b.lock.Lock()
+	wasClosed := b.state == CircuitClosed
+	b.close()
+	b.lock.Unlock()
+	if !wasClosed {
+		b.owner.notify()
+	}
+}
+
+// changed 状态变化后通知负载均衡器重新选取节点，并调用OnStateChange回调
+func (b *circuitBreaker) changed(config *CircuitBreakerConfig, from, to CircuitState) {
+	b.owner.notify()
+	if config != nil && config.OnStateChange != nil {
+		config.OnStateChange(b.node, from, to)
+	}
+}
+
+// pickReady 选取断路器就绪的节点，节点未就绪时重新选取，最多maxPickAttempts次
+func pickReady(get func() *lbClient) *lbClient {
+	c := get()
+	for i := 1; i < maxPickAttempts && !c.ready(); i++ {
+		c = get()
+	}
+	return c
+}
@@ -0,0 +1,168 @@
+package httplb_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var slow int32 = 1
+	addrA, stopA := startServer(t, okHandler)
+	defer stopA()
+	addrB, stopB := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		if atomic.LoadInt32(&slow) == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+	})
+	defer stopB()
+
+	var (
+		mu          sync.Mutex
+		transitions []string
+	)
+	cfg := newStaticConfig(httplb.LBRoundRobin, addrA, addrB)
+	cfg.Opts.ReadTimeout = 50 * time.Millisecond
+	cfg.Opts.MaxConns = 16
+	cfg.CircuitBreaker = &httplb.CircuitBreakerConfig{
+		ConsecutiveFailures: 2,
+		OpenTimeout:         100 * time.Millisecond,
+		OnStateChange: func(node *httplb.Node, from, to httplb.CircuitState) {
+			if node.Addr() == addrB {
+				mu.Lock()
+				transitions = append(transitions, fmt.Sprintf("%s->%s", from, to))
+				mu.Unlock()
+			}
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + addrA + "/")
+
+	circuit := func() httplb.CircuitState {
+		for _, s := range lb.Stats() {
+			if s.Node.Addr() == addrB {
+				return s.Circuit
+			}
+		}
+		t.Fatalf("node %s not found in stats", addrB)
+		return 0
+	}
+	waitFor := func(state httplb.CircuitState) {
+		deadline := time.Now().Add(5 * time.Second)
+		for circuit() != state {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for circuit %s, got %s", state, circuit())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	for i := 0; i < 10 && circuit() != httplb.CircuitOpen; i++ {
+		_ = lb.Do(req, resp)
+	}
+	if state := circuit(); state != httplb.CircuitOpen {
+		t.Fatalf("expected circuit to open, got %s", state)
+	}
+	// 断路器打开期间节点不参与选择
+	waitFor(httplb.CircuitOpen)
+	for i := 0; i < 10; i++ {
+		if c := lb.Get(); c.Node().Addr() == addrB && circuit() == httplb.CircuitOpen {
+			t.Fatalf("node %s with open circuit selected", c.Node().Addr())
+		}
+	}
+
+	// 半开状态下探测请求成功后关闭
+	atomic.StoreInt32(&slow, 0)
+	waitFor(httplb.CircuitHalfOpen)
+	for i := 0; i < 10 && circuit() != httplb.CircuitClosed; i++ {
+		if err := lb.Do(req, resp); err != nil && err != httplb.ErrCircuitOpen {
+			t.Fatal(err)
+		}
+	}
+	waitFor(httplb.CircuitClosed)
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if fmt.Sprint(transitions) != fmt.Sprint(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+}
+
+// DoContext到达截止时间视为节点超时，计入断路器
+func TestCircuitBreakerContextDeadline(t *testing.T) {
+	addr, stop := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	defer stop()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addr)
+	cfg.Opts.MaxConns = 16
+	cfg.CircuitBreaker = &httplb.CircuitBreakerConfig{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         time.Minute,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + addr + "/")
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := lb.DoContext(ctx, req, resp)
+		cancel()
+		if err == nil {
+			t.Fatal("expected timeout")
+		}
+	}
+	if s := lb.Stats()[0]; s.Circuit != httplb.CircuitOpen {
+		t.Fatalf("expected circuit open after timeouts, got %s", s.Circuit)
+	}
+}
+
+// Window小于分段数（纳秒）时调整为每个分段1ns，避免计算分段时除数为0
+func TestCircuitBreakerTinyWindow(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBRoundRobin, closedAddr(t))
+	cfg.CircuitBreaker = &httplb.CircuitBreakerConfig{Window: 5, OpenTimeout: time.Minute}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.CircuitBreaker.Window != 10 {
+		t.Fatalf("expected window clamped to 10ns, got %s", cfg.CircuitBreaker.Window)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/")
+	for i := 0; i < 3; i++ {
+		if err := lb.Do(req, resp); err == nil {
+			t.Fatal("expected connection error")
+		}
+	}
+}
@@ -1,21 +1,20 @@
 package httplb
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"regexp"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/valyala/fasthttp"
 
 	"http-loadbalance/libs/validate"
 )
 
-var (
-	dnsReg = regexp.MustCompile(`^([\d.]+):([\d]+)$`)
-)
-
 // Config 一组服务的配置
 //
 // 标识类别（static/dns/consul）等
@@ -23,13 +22,44 @@ var (
 // dns: 提供domain域名
 // consul: 提供服务发现地址
 type Config struct {
-	LBStrategy int           `toml:"lb_strategy"`              // 负载均衡策略 eg：LBRoundRobin, LBWeightRandom
-	Type       string        `toml:"type" validate:"required"` // "dns" or "consul" or "static"
-	Consul     *ConsulConfig `toml:"consul"`
-	DNS        *DnsConfig    `toml:"dns"`
-	IPList     []string      `toml:"ip_list"`   // 从配置文件中读取的host列表, 如果服务发现服务失效, 使用IPList
-	NodeList   []*Node       `toml:"node_list"` // 从配置文件中读取的host列表, 如果服务发现服务失效, 使用StaticHosts
-	Opts       *Opts         `toml:"opts"`
+	LBStrategy       LBStrategy              `toml:"lb_strategy"`              // 负载均衡策略 eg：LBRoundRobin, LBWeightRandom；TOML中也可使用策略名称，如"p2c"
+	Type             string                  `toml:"type" validate:"required"` // "dns" or "consul" or "static"，或RegisterDiscovery注册的类型
+	Consul           *ConsulConfig           `toml:"consul"`
+	DNS              *DnsConfig              `toml:"dns"`
+	IPList           []string                `toml:"ip_list"`                   // 从配置文件中读取的host列表, 如果服务发现服务失效, 使用IPList
+	NodeList         []*Node                 `toml:"node_list" validate:"dive"` // 从配置文件中读取的host列表, 如果服务发现服务失效, 使用StaticHosts
+	Opts             *Opts                   `toml:"opts"`
+	HashKey          *HashKeyConfig          `toml:"hash_key"`          // 一致性哈希等策略的key提取配置，默认使用URI路径
+	HealthCheck      *HealthCheckConfig      `toml:"health_check"`      // 主动健康检查配置，未配置时不进行主动健康检查
+	OutlierDetection *OutlierDetectionConfig `toml:"outlier_detection"` // 被动异常检测配置，未配置时不进行异常检测
+	CircuitBreaker   *CircuitBreakerConfig   `toml:"circuit_breaker"`   // 节点断路器配置，未配置时不启用断路器
+	Retry            *RetryConfig            `toml:"retry"`             // 失败后在其他节点上重试的配置，未配置时不重试
+	Hedge            *HedgeConfig            `toml:"hedge"`             // 对冲请求配置，未配置时不发送对冲请求
+	Discovery        *DiscoveryConfig        `toml:"discovery"`         // 节点更新的合并配置，未配置时立即应用服务发现推送的节点
+
+	// LoadMetric 随机二选一等策略比较节点负载的方式，可选值 pending / penalty / latency，默认penalty
+	LoadMetric string `toml:"load_metric" validate:"default=penalty,oneof=pending penalty latency"`
+
+	// 以下回调无法在配置文件中设置，可在New()、Manager.Add/Replace前设置，LoadTOML加载的服务通过Manager.Configure设置。
+	// 负载均衡器上的同名字段（如LeastLoadedLB.HealthCheck）设置后优先于这些回调
+
+	// HealthCheckFunc 请求完成后判断节点是否健康，返回false时增加节点的惩罚值，默认err不为nil时不健康
+	HealthCheckFunc func(req *fasthttp.Request, resp *fasthttp.Response, err error) bool `toml:"-"`
+	// HealthCheckContextFunc 同HealthCheckFunc，额外接收DoContext的ctx，设置后优先于HealthCheckFunc
+	HealthCheckContextFunc func(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, err error) bool `toml:"-"`
+	// LoadFunc LBPowerOfTwo计算节点的负载，值越小越优先，设置后优先于LoadMetric
+	LoadFunc func(c Client) float64 `toml:"-"`
+	// KeyFunc LBConsistentHash、LBMaglev从请求中提取哈希key，设置后优先于HashKey
+	KeyFunc func(req *fasthttp.Request) []byte `toml:"-"`
+
+	resources *resources // 多个服务间共享的Consul/DNS客户端，由Manager设置
+}
+
+// 未设置共享资源时，沿用旧配置的共享资源，以便UpdateConfig后仍与其他服务共享
+func (c *Config) inheritResources(old *Config) {
+	if c.resources == nil {
+		c.resources = old.resources
+	}
 }
 
 // Opts HTTP资源细节配置，如连接超时等
@@ -42,6 +72,9 @@ type Opts struct {
 	MaxConnDuration     time.Duration `toml:"max_conn_duration"`                      // 空闲
 	MaxIdleConnDuration time.Duration `toml:"max_idle_conn_duration"`                 // 空闲连接的keep alive 时间，默认10s
 	MaxCallAttempts     int           `toml:"max_call_attempts" validate:"default=1"` // 尝试请求次数，默认1
+	MaxConnWaitTimeout  time.Duration `toml:"max_conn_wait_timeout"`                  // 连接数达到MaxConns时等待空闲连接的最长时间，默认不等待
+	PeakEWMADecay       time.Duration `toml:"peak_ewma_decay"`                        // LBPeakEWMA策略耗时的衰减时间窗口，默认10s
+	RequestTimeout      time.Duration `toml:"request_timeout"`                        // Do请求的超时时间，包括选取节点、等待连接及读取响应；以LBLeastConnection创建时默认为ReadTimeout的2倍，其他情况或未配置ReadTimeout时默认DefaultLBClientTimeout
 }
 
 // 转换IPList格式，将配置文件中的[]string转换为[]*Node
@@ -67,14 +100,10 @@ func (c *Config) Validate() error {
 	}
 	switch strings.ToLower(c.Type) {
 	case TypeStatic:
+		// 各节点的字段在validate.Validator.Struct(c)中通过dive校验
 		if len(c.NodeList) == 0 {
 			return errors.New("type=statc static_ip_list cannot empty")
 		}
-		for _, node := range c.NodeList {
-			if err = node.Validate(); err != nil {
-				return err
-			}
-		}
 	case TypeConsul:
 		if c.Consul == nil {
 			return errors.New("type=consul consul config cannot empty")
@@ -89,24 +118,81 @@ func (c *Config) Validate() error {
 		if err = c.DNS.Validate(); err != nil {
 			return err
 		}
+	default:
+		// 自定义的服务发现来源需通过RegisterDiscovery注册
+		if _, ok := lookupDiscovery(c.Type); !ok && c.Type != "" {
+			return fmt.Errorf("httplb: unknown type %q", c.Type)
+		}
 	}
 	// 默认负载均衡策略设置为最小连接
 	if c.LBStrategy == 0 {
 		c.LBStrategy = LBLeastConnection
 	}
+	if _, ok := lookupStrategy(c.LBStrategy); !ok {
+		return fmt.Errorf("httplb: unknown lb_strategy %d", c.LBStrategy)
+	}
+	// 未配置opts时使用默认值
+	if c.Opts == nil {
+		c.Opts = &Opts{}
+	}
+	if c.HashKey != nil {
+		if err = c.HashKey.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.HealthCheck != nil {
+		if err = c.HealthCheck.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.OutlierDetection != nil {
+		if err = c.OutlierDetection.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.CircuitBreaker != nil {
+		if err = c.CircuitBreaker.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Retry != nil {
+		if err = c.Retry.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Hedge != nil {
+		if err = c.Hedge.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Discovery != nil {
+		if err = c.Discovery.Validate(); err != nil {
+			return err
+		}
+	}
 	return validate.Validator.Struct(c)
 }
 
 // DnsConfig DNS配置
+//
+// 按应答中最小的TTL重新解析，TTL限制在[MinTTL, MaxTTL]内；解析失败时每隔Interval重试。
+// 依次使用各DNS服务器，失败时切换到下一个；应答被截断时改用TCP重新查询。
+// 按ResolvFile中的search及ndots规则补全域名
 type DnsConfig struct {
-	Domain        string `toml:"domain" validate:"required"` // 域名
-	Type          string `toml:"type" validate:"default=A"`  // 类型，可选值 SRV / A，默认A记录
-	dnsType       uint16 // 转换为数值 dns.TypeA和dns.TypeSRV
-	Port          uint16 `toml:"port" validate:"default=80"`                      // A记录时所使用的端口，默认80；SRV不使用这个全局端口
-	ResolvFile    string `toml:"resolv_file" validate:"default=/etc/resolv.conf"` // dns server获取的文件路径
-	DNSServer     string `toml:"dns_server"`                                      // DNS服务器，格式："10.13.40.145:53"，如设置该值，则ResolvFile配置失效
-	dnsServerIP   string // DNS服务器IP，从DNSServer配置中解析
-	dnsServerPort string // DNS服务器的端口号，从DNSServer配置中解析
+	Domain     string   `toml:"domain" validate:"required"` // 域名
+	Type       string   `toml:"type" validate:"default=A"`  // 类型，可选值 SRV / A，默认A记录
+	dnsType    uint16   // 转换为数值 dns.TypeA和dns.TypeSRV
+	Port       uint16   `toml:"port" validate:"default=80"`                                               // A记录时所使用的端口，默认80；SRV不使用这个全局端口
+	Family     string   `toml:"family" validate:"default=v4,oneof=v4 v6 both prefer-ipv6 happy-eyeballs"` // 地址族，可选值 v4 / v6 / both / prefer-ipv6 / happy-eyeballs，默认v4
+	ResolvFile string   `toml:"resolv_file" validate:"default=/etc/resolv.conf"`                          // dns server获取的文件路径
+	DNSServer  string   `toml:"dns_server"`                                                               // DNS服务器，格式："10.13.40.145:53"，如设置该值，则不使用ResolvFile中的nameserver
+	DNSServers []string `toml:"dns_servers"`                                                              // 多个DNS服务器，格式同DNSServer，端口默认53，与DNSServer合并使用
+	servers    []string // 依次使用的DNS服务器地址，从DNSServer(s)或ResolvFile中解析
+	names      []string // 依次查询的完整域名，按ResolvFile的search及ndots规则生成
+
+	Interval time.Duration `toml:"interval"` // 解析失败时的重试间隔，默认10s
+	MinTTL   time.Duration `toml:"min_ttl"`  // 重新解析间隔的下限，默认5s
+	MaxTTL   time.Duration `toml:"max_ttl"`  // 重新解析间隔的上限，默认5分钟
 }
 
 func (dc *DnsConfig) Validate() error {
@@ -121,31 +207,309 @@ func (dc *DnsConfig) Validate() error {
 	default:
 		dc.dnsType = dns.TypeA
 	}
+	dc.servers = nil
+	servers := dc.DNSServers
 	if dc.DNSServer != "" {
-		g := dnsReg.FindAllStringSubmatch(dc.DNSServer, -1)
-		if len(g) < 1 || len(g[0]) < 3 {
-			return fmt.Errorf("[%s] dns_server with wrong format. check if \"IP:Port\"", dc.Domain)
-		}
-		dc.dnsServerIP = g[0][1]
-		dc.dnsServerPort = g[0][2]
-	} else {
-		config, err := dns.ClientConfigFromFile(dc.ResolvFile)
+		servers = append([]string{dc.DNSServer}, servers...)
+	}
+	for _, server := range servers {
+		addr, err := dnsServerAddr(server, "53")
 		if err != nil {
+			return fmt.Errorf("[%s] dns_server %q with wrong format. check if \"IP:Port\"", dc.Domain, server)
+		}
+		dc.servers = append(dc.servers, addr)
+	}
+	// 配置了DNS服务器时，ResolvFile仅提供search及ndots，读取失败时直接查询Domain
+	config, err := dns.ClientConfigFromFile(dc.ResolvFile)
+	if err != nil {
+		if len(dc.servers) == 0 {
 			return err
 		}
-		dc.dnsServerIP = config.Servers[0]
-		dc.dnsServerPort = config.Port
+		dc.names = []string{dns.Fqdn(dc.Domain)}
+	} else {
+		dc.names = config.NameList(dc.Domain)
+	}
+	if len(dc.servers) == 0 {
+		for _, server := range config.Servers {
+			addr, err := dnsServerAddr(server, config.Port)
+			if err != nil {
+				return fmt.Errorf("[%s] nameserver %q in %s with wrong format", dc.Domain, server, dc.ResolvFile)
+			}
+			dc.servers = append(dc.servers, addr)
+		}
+		if len(dc.servers) == 0 {
+			return fmt.Errorf("[%s] no nameserver found in %s", dc.Domain, dc.ResolvFile)
+		}
+	}
+	if dc.Interval <= 0 {
+		dc.Interval = defaultDNSResolverInterval
+	}
+	if dc.MinTTL <= 0 {
+		dc.MinTTL = defaultDNSMinTTL
+	}
+	if dc.MaxTTL <= 0 {
+		dc.MaxTTL = defaultDNSMaxTTL
+	}
+	if dc.MaxTTL < dc.MinTTL {
+		return fmt.Errorf("[%s] dns max_ttl [%s] must not be less than min_ttl [%s]", dc.Domain, dc.MaxTTL, dc.MinTTL)
+	}
+	return nil
+}
+
+// dnsServerAddr 解析DNS服务器地址，未指定端口时使用port
+func dnsServerAddr(server, port string) (string, error) {
+	host, p, err := net.SplitHostPort(server)
+	if err != nil {
+		host, p = server, port
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid dns server %q", server)
+	}
+	if _, err = strconv.ParseUint(p, 10, 16); err != nil {
+		return "", fmt.Errorf("invalid dns server %q", server)
+	}
+	return net.JoinHostPort(host, p), nil
+}
+
+// HashKeyConfig 按请求选择节点时的key提取配置
+type HashKeyConfig struct {
+	Source string `toml:"source" validate:"default=path"` // key来源，可选值 header / cookie / query / path，默认path
+	Name   string `toml:"name"`                           // header、cookie或query参数的名称
+}
+
+func (hc *HashKeyConfig) Validate() error {
+	err := validate.Validator.Struct(hc)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(hc.Source) {
+	case HashKeyHeader, HashKeyCookie, HashKeyQuery:
+		if hc.Name == "" {
+			return fmt.Errorf("hash_key source=%s name cannot empty", hc.Source)
+		}
+	case HashKeyPath:
+	default:
+		return fmt.Errorf("hash_key source [%s] not supported, use header/cookie/query/path", hc.Source)
 	}
 	return nil
 }
 
+// keyFunc 根据配置生成从请求中提取key的函数
+func (hc *HashKeyConfig) keyFunc() func(req *fasthttp.Request) []byte {
+	if hc == nil {
+		return func(req *fasthttp.Request) []byte {
+			return req.URI().Path()
+		}
+	}
+	name := hc.Name
+	switch strings.ToLower(hc.Source) {
+	case HashKeyHeader:
+		return func(req *fasthttp.Request) []byte {
+			return req.Header.Peek(name)
+		}
+	case HashKeyCookie:
+		return func(req *fasthttp.Request) []byte {
+			return req.Header.Cookie(name)
+		}
+	case HashKeyQuery:
+		return func(req *fasthttp.Request) []byte {
+			return req.URI().QueryArgs().Peek(name)
+		}
+	default:
+		return func(req *fasthttp.Request) []byte {
+			return req.URI().Path()
+		}
+	}
+}
+
 type ConsulConfig struct {
 	ConsulAgent string `toml:"consul_agent" validate:"default=127.0.0.1:8500"` // consul地址，默认127.0.0.1:8500
 	ServiceName string `toml:"service_name" validate:"required"`               // consul服务发现名称
 	TagName     string `toml:"tag_name"`                                       // consul服务tag名称
 	Token       string `toml:"token"`                                          // 所需要的token
+
+	// Interval 两次阻塞查询之间的最小间隔，用于降低变化频繁的服务的查询频率，默认0即立即发起下一次查询；
+	// 查询失败后的重试间隔为Interval，不小于5s
+	Interval time.Duration `toml:"interval"`
+	WaitTime time.Duration `toml:"wait_time"` // 阻塞查询的最长等待时间，默认由consul决定（5分钟）
 }
 
 func (c *ConsulConfig) Validate() error {
 	return validate.Validator.Struct(c)
 }
+
+// HealthCheckConfig 主动健康检查配置
+//
+// 每隔Interval向各节点发送一次探测请求，连续Fall次失败的节点从可选节点中移除，
+// 此后连续Rise次成功才重新加入
+type HealthCheckConfig struct {
+	Path             string        `toml:"path" validate:"default=/"`                                   // 探测请求的路径，默认/
+	Method           string        `toml:"method" validate:"default=GET"`                               // 探测请求的方法，默认GET
+	ExpectedStatuses []int         `toml:"expected_statuses" validate:"omitempty,dive,min=100,max=599"` // 视为健康的响应状态码，默认200
+	Body             string        `toml:"body"`                                                        // 响应内容需包含的字符串，为空时不检查
+	Interval         time.Duration `toml:"interval"`                                                    // 探测间隔，默认5s
+	Timeout          time.Duration `toml:"timeout"`                                                     // 探测请求的超时时间，默认1s
+	Rise             int           `toml:"rise" validate:"default=2,min=1"`                             // 不健康的节点连续成功多少次后恢复，默认2
+	Fall             int           `toml:"fall" validate:"default=3,min=1"`                             // 健康的节点连续失败多少次后移除，默认3
+}
+
+func (hc *HealthCheckConfig) Validate() error {
+	if err := validate.Validator.Struct(hc); err != nil {
+		return err
+	}
+	if len(hc.ExpectedStatuses) == 0 {
+		hc.ExpectedStatuses = []int{fasthttp.StatusOK}
+	}
+	if hc.Interval <= 0 {
+		hc.Interval = defaultHealthCheckInterval
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = defaultHealthCheckTimeout
+	}
+	if !strings.HasPrefix(hc.Path, "/") {
+		return fmt.Errorf("health_check path [%s] must start with /", hc.Path)
+	}
+	return nil
+}
+
+// OutlierDetectionConfig 被动异常检测配置
+//
+// 节点连续返回Consecutive5xx次5xx，或连续ConsecutiveGatewayFailure次网关错误（502/503/504及连接错误）时被移出；
+// 每个统计周期Interval内，成功率低于所有节点平均值-SuccessRateStdevFactor×标准差的节点也被移出。
+// 第n次移出的时间为BaseEjectionTime×2^(n-1)，不超过MaxEjectionTime
+type OutlierDetectionConfig struct {
+	Consecutive5xx            int           `toml:"consecutive_5xx" validate:"default=5,min=1"`               // 连续5xx的次数，默认5
+	ConsecutiveGatewayFailure int           `toml:"consecutive_gateway_failure" validate:"default=5,min=1"`   // 连续网关错误的次数，默认5
+	Interval                  time.Duration `toml:"interval"`                                                 // 统计周期，默认10s
+	BaseEjectionTime          time.Duration `toml:"base_ejection_time"`                                       // 首次移出的时间，默认30s
+	MaxEjectionTime           time.Duration `toml:"max_ejection_time"`                                        // 最长移出时间，默认300s
+	MaxEjectionPercent        int           `toml:"max_ejection_percent" validate:"default=10,min=1,max=100"` // 最多移出节点的百分比，默认10
+	SuccessRateMinimumHosts   int           `toml:"success_rate_minimum_hosts" validate:"default=5,min=1"`    // 按成功率检测所需的最少节点数，默认5
+	SuccessRateRequestVolume  int           `toml:"success_rate_request_volume" validate:"default=100,min=1"` // 节点参与成功率检测所需的最少请求数，默认100
+	SuccessRateStdevFactor    float64       `toml:"success_rate_stdev_factor" validate:"default=1.9,min=0"`   // 成功率低于平均值多少个标准差时移出，默认1.9
+}
+
+func (oc *OutlierDetectionConfig) Validate() error {
+	if err := validate.Validator.Struct(oc); err != nil {
+		return err
+	}
+	if oc.Interval <= 0 {
+		oc.Interval = defaultOutlierInterval
+	}
+	if oc.BaseEjectionTime <= 0 {
+		oc.BaseEjectionTime = defaultOutlierBaseEjectionTime
+	}
+	if oc.MaxEjectionTime <= 0 {
+		oc.MaxEjectionTime = defaultOutlierMaxEjectionTime
+	}
+	if oc.MaxEjectionTime < oc.BaseEjectionTime {
+		oc.MaxEjectionTime = oc.BaseEjectionTime
+	}
+	return nil
+}
+
+// CircuitBreakerConfig 节点断路器配置
+//
+// 滚动窗口Window内连续失败ConsecutiveFailures次，或请求数不少于MinRequests且错误率达到ErrorRate时断路器打开，
+// 打开期间节点不参与选择；OpenTimeout后进入半开状态，最多允许HalfOpenMaxRequests个探测请求，全部成功后关闭
+type CircuitBreakerConfig struct {
+	Window              time.Duration `toml:"window"`                                            // 滚动窗口大小，默认10s，最小10ns
+	ConsecutiveFailures int           `toml:"consecutive_failures" validate:"default=5,min=1"`   // 连续失败的次数，默认5
+	ErrorRate           float64       `toml:"error_rate" validate:"default=0.5,gt=0,lte=1"`      // 错误率阈值，取值范围(0, 1]，默认0.5
+	MinRequests         int           `toml:"min_requests" validate:"default=20,min=1"`          // 按错误率打开所需的窗口内最少请求数，默认20
+	OpenTimeout         time.Duration `toml:"open_timeout"`                                      // 打开后进入半开状态的时间，默认5s
+	HalfOpenMaxRequests int           `toml:"half_open_max_requests" validate:"default=1,min=1"` // 半开状态下允许的探测请求数，默认1
+
+	// OnStateChange 断路器状态变化时调用，在请求协程或定时器协程中执行，不应阻塞
+	OnStateChange func(node *Node, from, to CircuitState) `toml:"-"`
+}
+
+func (cc *CircuitBreakerConfig) Validate() error {
+	if err := validate.Validator.Struct(cc); err != nil {
+		return err
+	}
+	if cc.Window <= 0 {
+		cc.Window = defaultCircuitWindow
+	} else if cc.Window < circuitBuckets {
+		// 每个分段至少1ns，否则计算分段序号时除数为0
+		cc.Window = circuitBuckets
+	}
+	if cc.OpenTimeout <= 0 {
+		cc.OpenTimeout = defaultCircuitOpenTimeout
+	}
+	return nil
+}
+
+// RetryConfig 负载均衡器的重试配置
+//
+// 请求出现连接错误、超时或返回RetryStatuses中的状态码时，选取尚未尝试过的节点重试，最多MaxRetries次。
+// 默认只重试幂等请求（GET/HEAD/OPTIONS/TRACE/PUT/DELETE），RetryNonIdempotent或WithNonIdempotentRetry可放开该限制。
+// 最近10s内的重试次数不超过请求数×BudgetRatio+BudgetMinPerSecond×10
+type RetryConfig struct {
+	MaxRetries         int     `toml:"max_retries" validate:"default=2,min=1"`                   // 最多重试次数，默认2
+	RetryStatuses      []int   `toml:"retry_statuses" validate:"omitempty,dive,min=100,max=599"` // 需要重试的响应状态码，默认502/503/504
+	RetryNonIdempotent bool    `toml:"retry_non_idempotent"`                                     // 是否重试非幂等请求，默认false
+	BudgetRatio        float64 `toml:"budget_ratio" validate:"default=0.2,min=0"`                // 重试次数与请求数的比例上限，默认0.2
+	BudgetMinPerSecond int     `toml:"budget_min_per_second" validate:"default=10,min=0"`        // 每秒至少允许的重试次数，默认10
+}
+
+func (rc *RetryConfig) Validate() error {
+	if err := validate.Validator.Struct(rc); err != nil {
+		return err
+	}
+	if len(rc.RetryStatuses) == 0 {
+		rc.RetryStatuses = []int{fasthttp.StatusBadGateway, fasthttp.StatusServiceUnavailable, fasthttp.StatusGatewayTimeout}
+	}
+	return nil
+}
+
+// HedgeConfig 对冲请求配置
+//
+// 幂等请求在Delay内未返回时，向另一个节点发送相同的请求，最先成功的响应胜出，另一个请求被取消。
+// 配置Percentile后延迟取最近请求耗时的该百分位数，样本不足时使用Delay。
+// 最近10s内的对冲请求数不超过请求数×BudgetRatio+BudgetMinPerSecond×10。
+// 对冲的请求不再按Retry重试
+type HedgeConfig struct {
+	Delay              time.Duration `toml:"delay"`                                            // 发送对冲请求前等待的时间，默认100ms
+	Percentile         float64       `toml:"percentile" validate:"omitempty,gt=0,lt=100"`      // 按最近请求耗时的百分位数计算延迟，如95
+	BudgetRatio        float64       `toml:"budget_ratio" validate:"default=0.1,min=0"`        // 对冲请求数与请求数的比例上限，默认0.1
+	BudgetMinPerSecond int           `toml:"budget_min_per_second" validate:"default=5,min=0"` // 每秒至少允许的对冲请求数，默认5
+}
+
+func (hc *HedgeConfig) Validate() error {
+	if err := validate.Validator.Struct(hc); err != nil {
+		return err
+	}
+	if hc.Delay <= 0 {
+		hc.Delay = defaultHedgeDelay
+	}
+	return nil
+}
+
+// DiscoveryConfig 服务发现推送节点列表后的合并配置
+//
+// 收到新的节点列表后等待Debounce，期间再次收到则重新计时，只应用最后一次的节点列表，
+// 避免注册中心中节点反复上下线时频繁重建可选节点；连续变化时最多等待MaxDebounce。
+// New()最多等待FetchTimeout获取第一次非空的节点列表，超时则使用NodeList（为空时请求返回ErrNoNodes）；
+// UpdateConfig更换服务发现来源时同样最多等待FetchTimeout，超时则保留原配置并返回错误
+type DiscoveryConfig struct {
+	Debounce     time.Duration `toml:"debounce"`      // 等待节点列表稳定的时间，默认0即立即应用
+	MaxDebounce  time.Duration `toml:"max_debounce"`  // 连续变化时的最长等待时间，默认10倍Debounce
+	FetchTimeout time.Duration `toml:"fetch_timeout"` // 等待第一次节点列表的最长时间，默认10s
+}
+
+func (dc *DiscoveryConfig) Validate() error {
+	if dc.Debounce < 0 || dc.MaxDebounce < 0 || dc.FetchTimeout < 0 {
+		return errors.New("discovery debounce, max_debounce and fetch_timeout cannot be negative")
+	}
+	if dc.FetchTimeout == 0 {
+		dc.FetchTimeout = defaultDiscoveryFetchTimeout
+	}
+	if dc.MaxDebounce == 0 {
+		dc.MaxDebounce = dc.Debounce * 10
+	}
+	if dc.MaxDebounce < dc.Debounce {
+		return fmt.Errorf("discovery max_debounce [%s] must not be less than debounce [%s]", dc.MaxDebounce, dc.Debounce)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package httplb
+
+import (
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ConsistentHashLB 一致性哈希负载均衡，按请求中的key将请求固定到同一节点
+//
+// 使用ketama哈希环，每个节点按Node.Weight分配虚拟节点。
+// 节点增减时只有约1/N的key会映射到其他节点，适用于按key分片缓存的后端服务
+type ConsistentHashLB struct {
+	*balancer
+
+	// KeyFunc 从请求中提取一致性哈希的key，设置后优先于Config.KeyFunc，默认按Config.HashKey提取
+	//
+	// 返回空key时请求以轮询方式分配
+	KeyFunc func(req *fasthttp.Request) []byte
+
+	index   int32        // 请求中没有key时轮询使用
+	ring    atomic.Value // *hashRing
+	keyFunc atomic.Value // func(req *fasthttp.Request) []byte
+}
+
+// NewConsistentHashLB 创建一致性哈希负载均衡策略
+func NewConsistentHashLB(config *Config) *ConsistentHashLB {
+	return newConsistentHashLB(config, nil)
+}
+
+// newConsistentHashLB 创建负载均衡器，core为切换负载均衡策略前的balancer，为nil时创建新的balancer
+func newConsistentHashLB(config *Config, core *balancer) *ConsistentHashLB {
+	lb := &ConsistentHashLB{}
+	lb.balancer = newBalancer(lb, config, core)
+	return lb
+}
+
+// GetByKey 获取key在哈希环上对应的Client，key为空时以轮询方式获取，没有节点时返回nil
+func (cc *ConsistentHashLB) GetByKey(key []byte) Client {
+	if len(cc.cs.load()) == 0 {
+		return nil
+	}
+	return cc.getByKey(key)
+}
+
+func (cc *ConsistentHashLB) update(cs []*lbClient, config *Config) {
+	cc.ring.Store(newHashRing(cs))
+	if config.KeyFunc != nil {
+		cc.keyFunc.Store(config.KeyFunc)
+	} else {
+		cc.keyFunc.Store(config.HashKey.keyFunc())
+	}
+}
+
+// choose 按请求中的key选取节点，Get()时以轮询方式选取
+func (cc *ConsistentHashLB) choose(cs []*lbClient, req *fasthttp.Request) *lbClient {
+	if req == nil {
+		return cc.getByKey(nil)
+	}
+	keyFunc := cc.KeyFunc
+	if keyFunc == nil {
+		keyFunc = cc.keyFunc.Load().(func(req *fasthttp.Request) []byte)
+	}
+	return cc.getByKey(keyFunc(req))
+}
+
+func (cc *ConsistentHashLB) getByKey(key []byte) *lbClient {
+	// 哈希环与其节点列表一同发布，下标总是对应同一份列表
+	ring := cc.ring.Load().(*hashRing)
+	if len(key) == 0 {
+		i := atomic.AddInt32(&cc.index, 1)
+		return ring.cs[int(uint32(i))%len(ring.cs)]
+	}
+	return ring.cs[ring.get(key)]
+}
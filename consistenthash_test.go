@@ -0,0 +1,101 @@
+package httplb_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestConsistentHashRemap(t *testing.T) {
+	var ipList []string
+	for i := 1; i <= 10; i++ {
+		ipList = append(ipList, fmt.Sprintf("10.0.0.%d:8080 weight=100", i))
+	}
+	cfg := newStaticConfig(httplb.LBConsistentHash, ipList...)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg).(httplb.KeyedLoadBalancer)
+	defer lb.Close()
+
+	const keys = 10000
+	before := make([]string, keys)
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		before[i] = lb.GetByKey([]byte("key-" + strconv.Itoa(i))).Node().Addr()
+		counts[before[i]]++
+	}
+	for addr, n := range counts {
+		if n < keys/10/2 || n > keys/10*2 {
+			t.Fatalf("node %s got %d of %d keys, ring is unbalanced", addr, n, keys)
+		}
+	}
+
+	// 移除一个节点，只有该节点上的key会迁移
+	removed := "10.0.0.10:8080"
+	if err := lb.UpdateConfig(newStaticConfig(httplb.LBConsistentHash, ipList[:9]...)); err != nil {
+		t.Fatal(err)
+	}
+	moved := 0
+	for i := 0; i < keys; i++ {
+		addr := lb.GetByKey([]byte("key-" + strconv.Itoa(i))).Node().Addr()
+		if addr != before[i] {
+			if before[i] != removed {
+				t.Fatalf("key-%d moved from %s to %s", i, before[i], addr)
+			}
+			moved++
+		}
+	}
+	if moved != counts[removed] {
+		t.Fatalf("expected %d keys to move, got %d", counts[removed], moved)
+	}
+}
+
+func TestConsistentHashKeyFromHeader(t *testing.T) {
+	nameHandler := func(name string) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			ctx.SetBodyString(name)
+		}
+	}
+	addrA, stopA := startServer(t, nameHandler("a"))
+	defer stopA()
+	addrB, stopB := startServer(t, nameHandler("b"))
+	defer stopB()
+
+	cfg := newStaticConfig(httplb.LBConsistentHash, addrA, addrB)
+	cfg.HashKey = &httplb.HashKeyConfig{Source: httplb.HashKeyHeader, Name: "X-User-Id"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/api")
+	for i := 0; i < 20; i++ {
+		req.Header.Set("X-User-Id", strconv.Itoa(i))
+		var first string
+		for j := 0; j < 3; j++ {
+			if err := lb.Do(req, resp); err != nil {
+				t.Fatal(err)
+			}
+			if j == 0 {
+				first = string(resp.Body())
+			} else if string(resp.Body()) != first {
+				t.Fatalf("user %d routed to both %s and %s", i, first, resp.Body())
+			}
+		}
+	}
+
+	cfg.HashKey = &httplb.HashKeyConfig{Source: httplb.HashKeyHeader}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for hash_key without name")
+	}
+}
@@ -0,0 +1,96 @@
+package httplb
+
+import (
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+var (
+	defaultConsulRetryInterval = time.Second * 5 // consul查询失败后的最小重试间隔
+)
+
+// consulDiscovery 使用consul的阻塞查询持续监听健康节点的变化
+type consulDiscovery struct {
+	discovery
+	config    *ConsulConfig
+	resources *resources // Consul客户端，由Manager创建时在多个服务间共享
+	fallback  []*Node    // 首次查询失败时推送的节点列表，即配置中的IPList
+	waitIndex uint64
+	nodes     []*Node // 最近一次推送的节点列表
+}
+
+func newConsulDiscovery(config *Config) Discoverer {
+	res := config.resources
+	if res == nil {
+		res = newResources()
+	}
+	d := &consulDiscovery{
+		discovery: newDiscovery(),
+		config:    config.Consul,
+		resources: res,
+		fallback:  config.NodeList,
+	}
+	d.run(d.watch)
+	return d
+}
+
+func (d *consulDiscovery) watch() {
+	for {
+		nodes, err := d.query()
+		if err != nil {
+			if d.ctx.Err() != nil {
+				return
+			}
+			// 如果请求异常，首次使用配置中的节点列表，之后保留旧的节点列表
+			if d.nodes == nil && len(d.fallback) > 0 {
+				d.nodes = d.fallback
+				d.send(d.fallback)
+			}
+			retry := d.config.Interval
+			if retry < defaultConsulRetryInterval {
+				retry = defaultConsulRetryInterval
+			}
+			if !d.sleep(retry) {
+				return
+			}
+			continue
+		}
+		// 返回列表为空时保留旧的节点列表
+		if len(nodes) > 0 && !nodesEqual(nodes, d.nodes) {
+			d.nodes = nodes
+			d.send(nodes)
+		}
+		if d.config.Interval > 0 && !d.sleep(d.config.Interval) {
+			return
+		}
+	}
+}
+
+// query 阻塞查询服务的健康节点，节点变化或查询超时后返回
+func (d *consulDiscovery) query() ([]*Node, error) {
+	client, err := d.resources.consulClient(d.config.ConsulAgent)
+	if err != nil {
+		return nil, err
+	}
+	option := (&api.QueryOptions{
+		WaitIndex: d.waitIndex,
+		WaitTime:  d.config.WaitTime,
+		UseCache:  false,
+		Token:     d.config.Token,
+	}).WithContext(d.ctx)
+	entrys, meta, err := client.Health().Service(d.config.ServiceName, d.config.TagName, true, option)
+	if err != nil {
+		return nil, err
+	}
+	d.waitIndex = meta.LastIndex
+	nodes := make([]*Node, 0, len(entrys))
+	for _, entry := range entrys {
+		nodes = append(nodes, &Node{
+			IP:     entry.Service.Address,
+			Port:   uint16(entry.Service.Port),
+			Weight: uint16(entry.Service.Weights.Passing),
+		})
+	}
+	return nodes, nil
+}
@@ -0,0 +1,65 @@
+package httplb
+
+import (
+	"context"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+)
+
+// doContext 在ctx控制下执行do请求
+//
+// ctx到达截止时间或被取消时立即返回ctx.Err()，包括等待空闲连接的时间；
+// 请求副本在后台继续执行直至do自身超时（如HostClient的ReadTimeout），
+// 以避免慢节点上的并发请求超过MaxConns，与fasthttp.DoDeadline的处理方式一致
+func doContext(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response,
+	do func(req *fasthttp.Request, resp *fasthttp.Response) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		// 不可取消的ctx，如context.Background()
+		return do(req, resp)
+	}
+
+	// 请求在后台执行，req/resp在ctx取消后不能再被访问，因此使用副本。
+	// 请求副本不放回fasthttp的对象池：未设置User-Agent时HostClient会让请求头引用其共享的名称缓冲区，
+	// 复用该请求对象的CopyTo会写入这块共享内存
+	reqCopy := &fasthttp.Request{}
+	req.CopyTo(reqCopy)
+	respCopy := fasthttp.AcquireResponse()
+	respCopy.SkipBody = resp.SkipBody
+
+	var (
+		mu       sync.Mutex
+		canceled bool
+		ch       = make(chan error, 1)
+	)
+	go func() {
+		errDo := do(reqCopy, respCopy)
+		mu.Lock()
+		if !canceled {
+			respCopy.CopyTo(resp)
+			ch <- errDo
+		}
+		mu.Unlock()
+
+		fasthttp.ReleaseResponse(respCopy)
+	}()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		mu.Lock()
+		canceled = true
+		mu.Unlock()
+		select {
+		case err := <-ch:
+			// 请求在取消的同时已完成
+			return err
+		default:
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,161 @@
+package httplb_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestDoContext(t *testing.T) {
+	addr, stop := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/slow" {
+			time.Sleep(time.Millisecond * 500)
+		}
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	})
+	defer stop()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addr)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI("http://test/api")
+	if err := lb.DoContext(context.Background(), req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("unexpected status code %d", resp.StatusCode())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := lb.DoContext(ctx, req, resp); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	req.SetRequestURI("http://test/slow")
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	if err := lb.DoContext(ctx, req, resp); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Millisecond*300 {
+		t.Fatalf("DoContext returned after %s, deadline not respected", d)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+	if err := lb.DoContext(ctx, req, resp); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+// ctx到达截止时间后，后台仍在等待空闲连接的请求副本放弃，不会在连接空闲后发出
+func TestDoContextDeadlineConnWait(t *testing.T) {
+	var waited int32
+	addr, stop := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		switch string(ctx.Path()) {
+		case "/slow":
+			time.Sleep(300 * time.Millisecond)
+		case "/wait":
+			atomic.AddInt32(&waited, 1)
+		}
+	})
+	defer stop()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addr)
+	cfg.Opts.MaxConns = 1
+	cfg.Opts.MaxConnWaitTimeout = time.Second
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	slowDone := make(chan error, 1)
+	go func() {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+		req.SetRequestURI("http://test/slow")
+		slowDone <- lb.Do(req, resp)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/wait")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := lb.DoContext(ctx, req, resp); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if err := <-slowDone; err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&waited); n != 0 {
+		t.Fatalf("request was sent after its deadline %d times", n)
+	}
+}
+
+// HealthCheckContext收到DoContext的ctx，调用方取消后该ctx随之取消
+func TestHealthCheckContextReceivesCtx(t *testing.T) {
+	addr, stop := startServer(t, okHandler)
+	defer stop()
+
+	type ctxKey struct{}
+	got := make(chan context.Context, 1)
+	cfg := newStaticConfig(httplb.LBRoundRobin, addr)
+	cfg.HealthCheckContextFunc = func(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, err error) bool {
+		got <- ctx
+		return err == nil
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/")
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "trace"))
+	defer cancel()
+	if err := lb.DoContext(ctx, req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	hctx := <-got
+	if v, _ := hctx.Value(ctxKey{}).(string); v != "trace" {
+		t.Fatalf("HealthCheckContext did not receive the caller's ctx values, got %q", v)
+	}
+	cancel()
+	select {
+	case <-hctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("ctx passed to HealthCheckContext was not cancelled with the caller's ctx")
+	}
+	if hctx.Err() != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", hctx.Err())
+	}
+}
@@ -1,5 +1,10 @@
 package httplb
 
+import (
+	"io"
+	"sync"
+)
+
 // 根据旧的client和新的节点信息，判断是否需要更新节点
 // 如果未更新，则第二个参数返回false
 // 如果已更新，第一个参数返回新的HTTP Clients
@@ -63,3 +68,42 @@ func equals(oldClients []Client, nodes []*Node) bool {
 	}
 	return true
 }
+
+// 关闭所有HTTP Clients，等待进行中的请求完成后关闭连接
+func closeClients(clients []Client) {
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		closer, ok := c.(io.Closer)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(closer io.Closer) {
+			defer wg.Done()
+			_ = closer.Close()
+		}(closer)
+	}
+	wg.Wait()
+}
+
+// 关闭节点更新后不再使用的HTTP Clients，在后台等待其进行中的请求完成
+func closeRemovedClients(oldClients, newClients []Client) {
+	if removed := removedClients(oldClients, newClients); len(removed) > 0 {
+		go closeClients(removed)
+	}
+}
+
+// 获取oldClients中不再存在于newClients的HTTP Clients
+func removedClients(oldClients, newClients []Client) []Client {
+	newClientMap := make(map[Client]bool, len(newClients))
+	for _, c := range newClients {
+		newClientMap[c] = true
+	}
+	var removed []Client
+	for _, c := range oldClients {
+		if !newClientMap[c] {
+			removed = append(removed, c)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,184 @@
+package httplb
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Discoverer 服务发现来源，持续推送服务的节点列表
+//
+// 通过RegisterDiscovery注册后，Config.Type设置为注册的类型即可使用自定义的来源。
+// 内置的static、dns、consul同样以Discoverer实现
+type Discoverer interface {
+	// Updates 返回节点列表的更新通道，每次发送完整的节点列表，空列表会被忽略
+	//
+	// 负载均衡器创建时最多等待Discovery.FetchTimeout获取第一次非空的更新，应尽快发送当前的节点列表；
+	// 接收方未及时接收时，只需保留最新的节点列表。Close之后通道应被关闭
+	Updates() <-chan []*Node
+
+	// Close 停止监听并取消进行中的查询
+	Close() error
+}
+
+// DiscoveryFactory 根据配置创建服务发现来源，New()或UpdateConfig更换服务发现来源时调用
+type DiscoveryFactory func(config *Config) Discoverer
+
+var (
+	discoveryLock sync.RWMutex
+	discoveries   = make(map[string]DiscoveryFactory) // key为小写的类型名称
+)
+
+// RegisterDiscovery 注册自定义服务发现来源，Config.Type为typ（不区分大小写）时使用factory创建
+//
+// 应在init()中注册，类型为空、factory为nil或类型重复时panic
+func RegisterDiscovery(typ string, factory DiscoveryFactory) {
+	if typ == "" || factory == nil {
+		panic("httplb: RegisterDiscovery requires a type and a factory")
+	}
+	discoveryLock.Lock()
+	defer discoveryLock.Unlock()
+	key := strings.ToLower(typ)
+	if _, ok := discoveries[key]; ok {
+		panic("httplb: discovery registered twice: " + typ)
+	}
+	discoveries[key] = factory
+}
+
+// lookupDiscovery 获取已注册的服务发现来源
+func lookupDiscovery(typ string) (DiscoveryFactory, bool) {
+	discoveryLock.RLock()
+	defer discoveryLock.RUnlock()
+	factory, ok := discoveries[strings.ToLower(typ)]
+	return factory, ok
+}
+
+func init() {
+	RegisterDiscovery(TypeStatic, newStaticDiscovery)
+	RegisterDiscovery(TypeDNS, newDNSDiscovery)
+	RegisterDiscovery(TypeConsul, newConsulDiscovery)
+}
+
+// watcher 负载均衡器当前使用的服务发现来源
+//
+// 以指针区分不同的来源，Discoverer的具体类型不要求可比较
+type watcher struct {
+	Discoverer
+}
+
+// newWatcher 创建Config.Type对应的服务发现来源，类型未注册时（未通过Validate校验的配置）使用静态节点列表
+func newWatcher(config *Config) *watcher {
+	factory, ok := lookupDiscovery(config.Type)
+	if !ok {
+		factory = newStaticDiscovery
+	}
+	return &watcher{factory(config)}
+}
+
+// close 停止服务发现，取消进行中的DNS/Consul查询
+func (w *watcher) close() {
+	_ = w.Close()
+}
+
+// discovery 内置服务发现来源的公共部分
+//
+// 在后台协程中查询节点，通过只保留最新节点列表的通道推送，Close时取消查询并等待协程退出后关闭通道
+type discovery struct {
+	updates chan []*Node
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+func newDiscovery() discovery {
+	ctx, cancel := context.WithCancel(context.Background())
+	return discovery{
+		updates: make(chan []*Node, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// run 在后台协程中执行f，f返回后关闭更新通道
+func (d *discovery) run(f func()) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer close(d.updates)
+		f()
+	}()
+}
+
+// send 推送节点列表，丢弃接收方尚未接收的旧列表，只能在run的协程中调用
+func (d *discovery) send(nodes []*Node) {
+	// 推送前生成节点标记，之后来源与负载均衡器只读取，避免并发写入Node.name
+	for _, n := range nodes {
+		_ = n.String()
+	}
+	select {
+	case <-d.updates:
+	default:
+	}
+	d.updates <- nodes
+}
+
+// sleep 休眠指定时间，如果已关闭则返回false
+func (d *discovery) sleep(t time.Duration) bool {
+	timer := time.NewTimer(t)
+	defer timer.Stop()
+	select {
+	case <-d.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (d *discovery) Updates() <-chan []*Node {
+	return d.updates
+}
+
+func (d *discovery) Close() error {
+	d.cancel()
+	d.wg.Wait()
+	return nil
+}
+
+// staticDiscovery 静态节点列表，创建时推送Config.NodeList，之后不再变化
+type staticDiscovery struct {
+	discovery
+}
+
+func newStaticDiscovery(config *Config) Discoverer {
+	d := &staticDiscovery{discovery: newDiscovery()}
+	nodes := config.NodeList
+	d.run(func() {
+		d.send(nodes)
+		<-d.ctx.Done()
+	})
+	return d
+}
+
+// nodesEqual 判断两个节点列表是否一致，与顺序无关
+func nodesEqual(a, b []*Node) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	// 判断两个Node数组的String()值是否一致
+	sa := make([]string, 0, len(a))
+	sb := make([]string, 0, len(b))
+	for i := 0; i < len(a); i++ {
+		sa = append(sa, a[i].String())
+		sb = append(sb, b[i].String())
+	}
+	sort.Strings(sa)
+	sort.Strings(sb)
+	for i := 0; i < len(sa); i++ {
+		if sa[i] != sb[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package httplb_test
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+// testRegistry 测试用的服务发现来源，通过push推送节点列表
+type testRegistry struct {
+	updates chan []*httplb.Node
+	closed  int32
+}
+
+func (r *testRegistry) Updates() <-chan []*httplb.Node {
+	return r.updates
+}
+
+func (r *testRegistry) Close() error {
+	if atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		close(r.updates)
+	}
+	return nil
+}
+
+func (r *testRegistry) push(nodes ...*httplb.Node) {
+	r.updates <- nodes
+}
+
+var testRegistries = make(chan *testRegistry, 1)
+
+func init() {
+	httplb.RegisterDiscovery("test_registry", func(config *httplb.Config) httplb.Discoverer {
+		r := &testRegistry{updates: make(chan []*httplb.Node, 1)}
+		r.push(config.NodeList...)
+		testRegistries <- r
+		return r
+	})
+}
+
+func statsAddrs(lb httplb.LoadBalancer) []string {
+	var addrs []string
+	for _, s := range lb.Stats() {
+		addrs = append(addrs, s.Node.Addr())
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func TestRegisterDiscovery(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBRoundRobin, "10.0.0.1:8080")
+	cfg.Type = "test_registry"
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	r := <-testRegistries
+
+	if addrs := statsAddrs(lb); len(addrs) != 1 || addrs[0] != "10.0.0.1:8080" {
+		t.Fatalf("unexpected initial nodes %v", addrs)
+	}
+
+	r.push(&httplb.Node{IP: "10.0.0.2", Port: 8080, Weight: 100}, &httplb.Node{IP: "10.0.0.3", Port: 8080, Weight: 100})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		addrs := statsAddrs(lb)
+		if len(addrs) == 2 && addrs[0] == "10.0.0.2:8080" && addrs[1] == "10.0.0.3:8080" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pushed nodes were not applied, got %v", addrs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	lb.Close()
+	if atomic.LoadInt32(&r.closed) == 0 {
+		t.Fatal("Discoverer was not closed")
+	}
+}
+
+func TestUnknownDiscoveryType(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBRoundRobin, "10.0.0.1:8080")
+	cfg.Type = "no_such_registry"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected unknown type to fail Validate")
+	}
+}
+
+func TestDiscoveryDebounce(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBRoundRobin, "10.0.0.1:8080")
+	cfg.Type = "test_registry"
+	cfg.Discovery = &httplb.DiscoveryConfig{Debounce: 200 * time.Millisecond}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	r := <-testRegistries
+
+	// 节点反复变化时只应用最后一次的节点列表
+	r.push(&httplb.Node{IP: "10.0.0.2", Port: 8080, Weight: 100})
+	time.Sleep(50 * time.Millisecond)
+	r.push(&httplb.Node{IP: "10.0.0.3", Port: 8080, Weight: 100})
+	time.Sleep(100 * time.Millisecond)
+	if addrs := statsAddrs(lb); len(addrs) != 1 || addrs[0] != "10.0.0.1:8080" {
+		t.Fatalf("nodes applied before debounce elapsed: %v", addrs)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		addrs := statsAddrs(lb)
+		if len(addrs) == 1 && addrs[0] == "10.0.0.3:8080" {
+			break
+		}
+		if addrs[0] == "10.0.0.2:8080" {
+			t.Fatal("intermediate node list was applied")
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("debounced nodes were not applied, got %v", addrs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// closedRegistry 创建后立即关闭的服务发现来源
+type closedRegistry struct{}
+
+func (closedRegistry) Updates() <-chan []*httplb.Node {
+	ch := make(chan []*httplb.Node)
+	close(ch)
+	return ch
+}
+
+func (closedRegistry) Close() error { return nil }
+
+func init() {
+	httplb.RegisterDiscovery("test_closed_registry", func(config *httplb.Config) httplb.Discoverer {
+		return closedRegistry{}
+	})
+}
+
+// 服务发现只推送空的节点列表或已关闭时，New在FetchTimeout内返回，请求返回ErrNoNodes，之后推送的节点列表正常应用
+func TestDiscoveryNoInitialNodes(t *testing.T) {
+	addr, stop := startServer(t, okHandler)
+	defer stop()
+
+	cfg := &httplb.Config{
+		Type:       "test_registry",
+		LBStrategy: httplb.LBRoundRobin,
+		Discovery:  &httplb.DiscoveryConfig{FetchTimeout: 100 * time.Millisecond},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	r := <-testRegistries
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("New blocked for %s", elapsed)
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/")
+	if c := lb.Get(); c != nil {
+		t.Fatalf("expected no client, got %s", c.Node().Addr())
+	}
+	if err := lb.Do(req, resp); err != httplb.ErrNoNodes {
+		t.Fatalf("expected ErrNoNodes, got %v", err)
+	}
+
+	host, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+	r.push(&httplb.Node{IP: host, Port: uint16(portNum), Weight: 100})
+	waitAddrs(t, lb, addr)
+	if err := lb.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = &httplb.Config{Type: "test_closed_registry", LBStrategy: httplb.LBConsistentHash}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	closed := httplb.New(cfg)
+	defer closed.Close()
+	if c := closed.(httplb.KeyedLoadBalancer).GetByKey([]byte("k")); c != nil {
+		t.Fatalf("expected no client, got %s", c.Node().Addr())
+	}
+	if err := closed.DoContext(context.Background(), req, resp); err != httplb.ErrNoNodes {
+		t.Fatalf("expected ErrNoNodes, got %v", err)
+	}
+}
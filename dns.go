@@ -0,0 +1,392 @@
+package httplb
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	defaultDNSResolverInterval = time.Second * 10 // 解析失败时的重试间隔，默认10s
+	defaultDNSMinTTL           = time.Second * 5  // 重新解析间隔的下限，默认5s
+	defaultDNSMaxTTL           = time.Minute * 5  // 重新解析间隔的上限，默认5分钟
+)
+
+var errDNSServersFailed = errors.New("all dns servers failed")
+
+// dnsDiscovery 通过DNS A或SRV记录发现节点，按TTL重新解析，节点变化时推送
+type dnsDiscovery struct {
+	discovery
+	config    *DnsConfig
+	dnsClient *dns.Client
+	tcpClient *dns.Client // UDP应答被截断时使用
+	server    int         // 下次查询首先使用的DNS服务器，为最近一次成功应答的服务器
+	fallback  []*Node     // 首次解析失败时推送的节点列表，即配置中的IPList
+	nodes     []*Node     // 最近一次推送的节点列表
+}
+
+func newDNSDiscovery(config *Config) Discoverer {
+	res := config.resources
+	if res == nil {
+		res = newResources()
+	}
+	d := &dnsDiscovery{
+		discovery: newDiscovery(),
+		config:    config.DNS,
+		dnsClient: res.dnsClient,
+		tcpClient: res.dnsTCPClient,
+		fallback:  config.NodeList,
+	}
+	d.run(d.watch)
+	return d
+}
+
+func (d *dnsDiscovery) watch() {
+	for {
+		var (
+			nodes []*Node
+			ttl   time.Duration
+			err   error
+		)
+		switch strings.ToUpper(d.config.Type) {
+		case DNSTypeSRV:
+			nodes, ttl, err = d.digSRV()
+		default:
+			nodes, ttl, err = d.digA()
+		}
+		if err == nil && len(nodes) == 0 {
+			logf("dns lookup %s %s: no record found", d.config.Domain, d.config.Type)
+		}
+		if d.ctx.Err() != nil {
+			return
+		}
+		switch {
+		case len(nodes) > 0 && !nodesEqual(nodes, d.nodes):
+			d.nodes = nodes
+			d.send(nodes)
+		case len(nodes) == 0 && d.nodes == nil && len(d.fallback) > 0:
+			// 与consul一致，首次解析失败时使用配置中的节点列表，之后保留旧的节点列表
+			d.nodes = d.fallback
+			d.send(d.fallback)
+		}
+		// 解析成功时按TTL休眠，失败时按Interval重试
+		interval := d.config.Interval
+		if len(nodes) > 0 {
+			interval = d.clampTTL(ttl)
+		}
+		if !d.sleep(interval) {
+			return
+		}
+	}
+}
+
+// clampTTL 将TTL限制在[MinTTL, MaxTTL]内
+func (d *dnsDiscovery) clampTTL(ttl time.Duration) time.Duration {
+	if ttl < d.config.MinTTL {
+		return d.config.MinTTL
+	}
+	if ttl > d.config.MaxTTL {
+		return d.config.MaxTTL
+	}
+	return ttl
+}
+
+// lookup 按search及ndots规则依次查询各候选域名，返回第一个包含qtype记录的应答，
+// 均没有qtype记录时返回nil，所有DNS服务器均查询失败时返回错误
+func (d *dnsDiscovery) lookup(qtype uint16) (*dns.Msg, error) {
+	for _, name := range d.config.names {
+		resp, err := d.exchange(name, qtype)
+		if err != nil {
+			if d.ctx.Err() == nil {
+				logf("dns lookup %s %s: %v", name, dns.TypeToString[qtype], err)
+			}
+			return nil, err
+		}
+		if resp.Rcode == dns.RcodeSuccess && hasRecord(resp.Answer, qtype) {
+			return resp, nil
+		}
+	}
+	return nil, nil
+}
+
+// exchange 从最近一次成功的DNS服务器开始依次查询，直到得到有效的应答（包括NXDOMAIN），
+// UDP应答被截断时改用TCP重新查询
+func (d *dnsDiscovery) exchange(name string, qtype uint16) (*dns.Msg, error) {
+	// 构建dns请求体
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = true
+
+	servers := d.config.servers
+	for i := 0; i < len(servers); i++ {
+		idx := (d.server + i) % len(servers)
+		addr := servers[idx]
+		msg.Id = dns.Id()
+		resp, _, err := d.dnsClient.ExchangeContext(d.ctx, msg, addr)
+		if err == nil && resp.Truncated {
+			resp, _, err = d.tcpClient.ExchangeContext(d.ctx, msg, addr)
+		}
+		if err != nil {
+			if d.ctx.Err() != nil {
+				return nil, d.ctx.Err()
+			}
+			logf("dns server %s query %s %s: %v", addr, name, dns.TypeToString[qtype], err)
+			continue
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			logf("dns server %s query %s %s: %s", addr, name, dns.TypeToString[qtype], dns.RcodeToString[resp.Rcode])
+			continue
+		}
+		d.server = idx
+		return resp, nil
+	}
+	return nil, errDNSServersFailed
+}
+
+// hasRecord 判断记录中是否包含qtype类型的记录
+func hasRecord(rrs []dns.RR, qtype uint16) bool {
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// minTTL 获取记录中最小的TTL，忽略OPT等伪记录
+func minTTL(rrs ...[]dns.RR) time.Duration {
+	var ttl uint32
+	found := false
+	for _, list := range rrs {
+		for _, rr := range list {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if t := rr.Header().Ttl; !found || t < ttl {
+				ttl, found = t, true
+			}
+		}
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// addrTypes 按地址族获取需要查询的地址记录类型，prefer-ipv6及happy-eyeballs时AAAA在前
+func addrTypes(family string) []uint16 {
+	switch family {
+	case DNSFamilyV6:
+		return []uint16{dns.TypeAAAA}
+	case DNSFamilyBoth:
+		return []uint16{dns.TypeA, dns.TypeAAAA}
+	case DNSFamilyPreferIPv6, DNSFamilyHappyEyeballs:
+		return []uint16{dns.TypeAAAA, dns.TypeA}
+	default:
+		return []uint16{dns.TypeA}
+	}
+}
+
+// rrIP 获取A或AAAA记录中的IP地址
+func rrIP(rr dns.RR) (string, bool) {
+	switch r := rr.(type) {
+	case *dns.A:
+		return r.A.String(), true
+	case *dns.AAAA:
+		return r.AAAA.String(), true
+	}
+	return "", false
+}
+
+// digA 按地址族获取域名的A/AAAA记录，并拼接为节点列表，同时返回应答的最小TTL
+//
+// prefer-ipv6时域名有AAAA记录则只使用IPv6地址，否则使用A记录；
+// happy-eyeballs时同样以IPv6地址为节点，IPv4地址作为连接时的回退地址
+func (d *dnsDiscovery) digA() ([]*Node, time.Duration, error) {
+	var answer []dns.RR
+	for _, qtype := range addrTypes(d.config.Family) {
+		resp, err := d.lookup(qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		if resp == nil {
+			continue
+		}
+		answer = append(answer, resp.Answer...)
+		if d.config.Family == DNSFamilyPreferIPv6 {
+			break
+		}
+	}
+	var ips []string
+	for _, ans := range answer {
+		if ip, ok := rrIP(ans); ok {
+			ips = append(ips, ip)
+		}
+	}
+	primary, fallback := splitFallback(ips, d.config.Family)
+	nodes := make([]*Node, 0, len(primary))
+	for i, ip := range primary {
+		nodes = append(nodes, &Node{
+			IP:       ip,
+			Port:     d.config.Port,
+			Weight:   100, // DNS A记录，权重没有意义，统一一致的权重即可
+			fallback: rotate(fallback, i),
+		})
+	}
+	return nodes, minTTL(answer), nil
+}
+
+// digSRV 获取域名SRV信息，并拼接为节点列表，同时返回应答的最小TTL
+//
+// 附加记录中没有Target地址时单独查询Target的A/AAAA记录，仍无法解析的Target被丢弃
+func (d *dnsDiscovery) digSRV() ([]*Node, time.Duration, error) {
+	resp, err := d.lookup(dns.TypeSRV)
+	if resp == nil {
+		return nil, 0, err
+	}
+	targetIPs := srvExtraIPs(resp.Extra, d.config.Family)
+	ttl := minTTL(resp.Answer, resp.Extra)
+	nodes := make([]*Node, 0, len(resp.Answer))
+	for _, ans := range resp.Answer {
+		srv, ok := ans.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		if srv.Target == "." {
+			// RFC 2782: Target为"."表示该域名不提供此服务
+			logf("dns srv %s: target \".\" means service not available, dropped", srv.Hdr.Name)
+			continue
+		}
+		target := strings.ToLower(srv.Target) // 域名不区分大小写
+		ips, ok := targetIPs[target]
+		if !ok {
+			var answer []dns.RR
+			if ips, answer, err = d.resolveTarget(srv.Target); err != nil {
+				return nil, 0, err
+			}
+			if len(ips) == 0 {
+				logf("dns srv %s: target %s has no %s address, dropped", srv.Hdr.Name, srv.Target, d.config.Family)
+				continue
+			}
+			targetIPs[target] = ips
+			if t := minTTL(answer); t < ttl {
+				ttl = t
+			}
+		}
+		primary, fallback := splitFallback(ips, d.config.Family)
+		for i, ip := range primary {
+			nodes = append(nodes, &Node{
+				IP:       ip,
+				Port:     srv.Port,
+				Weight:   splitWeight(srv.Weight, i, len(primary)),
+				Priority: srv.Priority,
+				fallback: rotate(fallback, i),
+			})
+		}
+	}
+	return nodes, ttl, nil
+}
+
+// splitFallback happy-eyeballs时将同一域名的地址分为作为节点的IPv6地址及连接时回退的IPv4地址，
+// 没有IPv6地址时直接以IPv4地址为节点；其他地址族的地址全部作为节点
+func splitFallback(ips []string, family string) (primary, fallback []string) {
+	if family != DNSFamilyHappyEyeballs {
+		return ips, nil
+	}
+	for _, ip := range ips {
+		if strings.Contains(ip, ":") {
+			primary = append(primary, ip)
+		} else {
+			fallback = append(fallback, ip)
+		}
+	}
+	if len(primary) == 0 {
+		return fallback, nil
+	}
+	return primary, fallback
+}
+
+// rotate 返回从第i个元素开始的轮转副本，使各节点优先回退到不同的IPv4地址
+func rotate(ips []string, i int) []string {
+	if len(ips) == 0 {
+		return nil
+	}
+	i %= len(ips)
+	return append(append(make([]string, 0, len(ips)), ips[i:]...), ips[:i]...)
+}
+
+// splitWeight 将SRV记录的权重平均分给Target的n个地址，返回第i个地址的权重，
+// 使该Target的请求比例不因地址数变化；余数分给前面的地址，权重不为0时每个地址至少为1
+func splitWeight(weight uint16, i, n int) uint16 {
+	if weight == 0 || n <= 1 {
+		return weight
+	}
+	w := int(weight) / n
+	if i < int(weight)%n {
+		w++
+	}
+	if w == 0 {
+		w = 1
+	}
+	return uint16(w)
+}
+
+// resolveTarget 按地址族查询SRV Target的A/AAAA记录，Target已是完整域名，不使用search规则
+func (d *dnsDiscovery) resolveTarget(target string) ([]string, []dns.RR, error) {
+	var (
+		ips    []string
+		answer []dns.RR
+	)
+	for _, qtype := range addrTypes(d.config.Family) {
+		resp, err := d.exchange(target, qtype)
+		if err != nil {
+			if d.ctx.Err() == nil {
+				logf("dns lookup %s %s: %v", target, dns.TypeToString[qtype], err)
+			}
+			return nil, nil, err
+		}
+		if resp.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		for _, rr := range resp.Answer {
+			if ip, ok := rrIP(rr); ok && rr.Header().Rrtype == qtype {
+				ips = append(ips, ip)
+				answer = append(answer, rr)
+			}
+		}
+		if d.config.Family == DNSFamilyPreferIPv6 && len(ips) > 0 {
+			break
+		}
+	}
+	return ips, answer, nil
+}
+
+// srvExtraIPs 根据SRV应答附加的A/AAAA记录，按地址族获取各Target的地址
+func srvExtraIPs(extra []dns.RR, family string) map[string][]string {
+	typed := make(map[string]map[uint16][]string) // SRV中的Target字段与各类型地址的映射
+	for _, ext := range extra {
+		ip, ok := rrIP(ext)
+		if !ok {
+			continue
+		}
+		hdr := ext.Header()
+		name := strings.ToLower(hdr.Name)
+		if typed[name] == nil {
+			typed[name] = make(map[uint16][]string)
+		}
+		typed[name][hdr.Rrtype] = append(typed[name][hdr.Rrtype], ip)
+	}
+	targetIPs := make(map[string][]string, len(typed))
+	for target, byType := range typed {
+		var ips []string
+		for _, qtype := range addrTypes(family) {
+			ips = append(ips, byType[qtype]...)
+			if family == DNSFamilyPreferIPv6 && len(ips) > 0 {
+				break
+			}
+		}
+		if len(ips) > 0 {
+			targetIPs[target] = ips
+		}
+	}
+	return targetIPs
+}
@@ -0,0 +1,454 @@
+package httplb_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+// startDNSServer 在同一端口上启动UDP及TCP的DNS服务
+func startDNSServer(t *testing.T, handler dns.HandlerFunc) (string, func()) {
+	var (
+		pc  net.PacketConn
+		l   net.Listener
+		err error
+	)
+	for i := 0; i < 10; i++ {
+		if pc, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if l, err = net.Listen("tcp", pc.LocalAddr().String()); err == nil {
+			break
+		}
+		pc.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp := &dns.Server{PacketConn: pc, Handler: handler}
+	tcp := &dns.Server{Listener: l, Handler: handler}
+	go udp.ActivateAndServe()
+	go tcp.ActivateAndServe()
+	return pc.LocalAddr().String(), func() {
+		udp.Shutdown()
+		tcp.Shutdown()
+	}
+}
+
+func aRecord(name, ip string, ttl uint32) dns.RR {
+	return &dns.A{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		A:   net.ParseIP(ip),
+	}
+}
+
+func waitAddrs(t *testing.T, lb httplb.LoadBalancer, want ...string) {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		addrs := statsAddrs(lb)
+		if len(addrs) == len(want) {
+			equal := true
+			for i := range want {
+				equal = equal && addrs[i] == want[i]
+			}
+			if equal {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected nodes %v, got %v", want, addrs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 第一个DNS服务器不可用时使用下一个，应答被截断时改用TCP，并按TTL（不超过MaxTTL）重新解析
+func TestDNSFailoverTruncatedTTL(t *testing.T) {
+	var (
+		ip        atomic.Value
+		tcpAnswer int32
+	)
+	ip.Store("10.0.0.1")
+	addr, stop := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			m.Truncated = true
+		} else {
+			atomic.AddInt32(&tcpAnswer, 1)
+			m.Answer = append(m.Answer, aRecord(r.Question[0].Name, ip.Load().(string), 300))
+		}
+		w.WriteMsg(m)
+	})
+	defer stop()
+
+	cfg := &httplb.Config{
+		Type:       httplb.TypeDNS,
+		LBStrategy: httplb.LBRoundRobin,
+		DNS: &httplb.DnsConfig{
+			Domain:     "svc.example.com",
+			Port:       8080,
+			ResolvFile: filepath.Join(os.TempDir(), "httplb-no-such-resolv.conf"),
+			DNSServers: []string{closedUDPAddr(t), addr},
+			MinTTL:     10 * time.Millisecond,
+			MaxTTL:     50 * time.Millisecond,
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	waitAddrs(t, lb, "10.0.0.1:8080")
+	if atomic.LoadInt32(&tcpAnswer) == 0 {
+		t.Fatal("truncated response was not retried over tcp")
+	}
+
+	ip.Store("10.0.0.2")
+	waitAddrs(t, lb, "10.0.0.2:8080")
+}
+
+// 按resolv.conf中的search及ndots补全域名
+func TestDNSSearchDomains(t *testing.T) {
+	addr, stop := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "api.prod.svc.local." {
+			m.Answer = append(m.Answer, aRecord(r.Question[0].Name, "10.0.1.1", 60))
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "httplb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	resolv := filepath.Join(dir, "resolv.conf")
+	content := "nameserver 127.0.0.1\nsearch example.com svc.local\noptions ndots:3\n"
+	if err := ioutil.WriteFile(resolv, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &httplb.Config{
+		Type:       httplb.TypeDNS,
+		LBStrategy: httplb.LBRoundRobin,
+		DNS: &httplb.DnsConfig{
+			Domain:     "api.prod",
+			ResolvFile: resolv,
+			DNSServer:  addr,
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	waitAddrs(t, lb, "10.0.1.1:80")
+}
+
+func TestDNSConfigValidate(t *testing.T) {
+	bad := []*httplb.DnsConfig{
+		{Domain: "svc.example.com", DNSServers: []string{"not-an-ip"}},
+		{Domain: "svc.example.com", DNSServers: []string{"127.0.0.1:99999"}},
+		{Domain: "svc.example.com", DNSServer: "127.0.0.1:53", MinTTL: time.Minute, MaxTTL: time.Second},
+	}
+	for _, dc := range bad {
+		if err := dc.Validate(); err == nil {
+			t.Fatalf("expected error for %+v", dc)
+		}
+	}
+	dc := &httplb.DnsConfig{Domain: "svc.example.com", DNSServers: []string{"127.0.0.1", "[::1]:5353"}}
+	if err := dc.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if dc.MinTTL <= 0 || dc.MaxTTL < dc.MinTTL || dc.Interval <= 0 {
+		t.Fatalf("defaults not applied: %+v", dc)
+	}
+}
+
+// closedUDPAddr 获取一个没有监听的UDP地址
+func closedUDPAddr(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+	return addr
+}
+
+func aaaaRecord(name, ip string, ttl uint32) dns.RR {
+	return &dns.AAAA{
+		Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: ttl},
+		AAAA: net.ParseIP(ip),
+	}
+}
+
+func srvRecord(name, target string, port uint16, ttl uint32) dns.RR {
+	return &dns.SRV{
+		Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+		Weight: 10,
+		Port:   port,
+		Target: target,
+	}
+}
+
+// 按地址族选择A/AAAA记录，SRV的Target从附加的A/AAAA记录中获取地址
+func TestDNSFamily(t *testing.T) {
+	addr, stop := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		switch {
+		case q.Name == "dual.example.com." && q.Qtype == dns.TypeA:
+			m.Answer = append(m.Answer, aRecord(q.Name, "10.0.0.1", 60))
+		case q.Name == "dual.example.com." && q.Qtype == dns.TypeAAAA:
+			m.Answer = append(m.Answer, aaaaRecord(q.Name, "2001:db8::1", 60))
+		case q.Name == "v4.example.com." && q.Qtype == dns.TypeA:
+			m.Answer = append(m.Answer, aRecord(q.Name, "10.0.0.2", 60))
+		case q.Name == "_http._tcp.example.com." && q.Qtype == dns.TypeSRV:
+			m.Answer = append(m.Answer,
+				srvRecord(q.Name, "a.example.com.", 8080, 60),
+				srvRecord(q.Name, "b.example.com.", 8081, 60))
+			m.Extra = append(m.Extra,
+				aRecord("a.example.com.", "10.0.1.1", 60),
+				aaaaRecord("a.example.com.", "2001:db8::a", 60),
+				aRecord("b.example.com.", "10.0.1.2", 60))
+		}
+		w.WriteMsg(m)
+	})
+	defer stop()
+
+	cases := []struct {
+		domain string
+		typ    string
+		family string
+		want   []string
+	}{
+		{"dual.example.com", httplb.DNSTypeA, httplb.DNSFamilyV4, []string{"10.0.0.1:80"}},
+		{"dual.example.com", httplb.DNSTypeA, httplb.DNSFamilyV6, []string{"[2001:db8::1]:80"}},
+		{"dual.example.com", httplb.DNSTypeA, httplb.DNSFamilyBoth, []string{"10.0.0.1:80", "[2001:db8::1]:80"}},
+		{"dual.example.com", httplb.DNSTypeA, httplb.DNSFamilyPreferIPv6, []string{"[2001:db8::1]:80"}},
+		{"v4.example.com", httplb.DNSTypeA, httplb.DNSFamilyPreferIPv6, []string{"10.0.0.2:80"}},
+		{"dual.example.com", httplb.DNSTypeA, httplb.DNSFamilyHappyEyeballs, []string{"[2001:db8::1]:80"}},
+		{"v4.example.com", httplb.DNSTypeA, httplb.DNSFamilyHappyEyeballs, []string{"10.0.0.2:80"}},
+		{"_http._tcp.example.com", httplb.DNSTypeSRV, httplb.DNSFamilyBoth, []string{"10.0.1.1:8080", "10.0.1.2:8081", "[2001:db8::a]:8080"}},
+		{"_http._tcp.example.com", httplb.DNSTypeSRV, httplb.DNSFamilyPreferIPv6, []string{"10.0.1.2:8081", "[2001:db8::a]:8080"}},
+	}
+	for _, c := range cases {
+		cfg := &httplb.Config{
+			Type:       httplb.TypeDNS,
+			LBStrategy: httplb.LBRoundRobin,
+			DNS: &httplb.DnsConfig{
+				Domain:    c.domain,
+				Type:      c.typ,
+				Family:    c.family,
+				DNSServer: addr,
+			},
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		lb := httplb.New(cfg)
+		waitAddrs(t, lb, c.want...)
+		lb.Close()
+	}
+
+	dc := &httplb.DnsConfig{Domain: "dual.example.com", DNSServer: addr, Family: "v5"}
+	if err := dc.Validate(); err == nil {
+		t.Fatal("expected error for unknown family")
+	}
+}
+
+// 多地址的SRV Target按地址数平分权重
+func TestDNSSRVSplitWeight(t *testing.T) {
+	addr, stop := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		if q.Qtype == dns.TypeSRV {
+			m.Answer = append(m.Answer,
+				srvRecord(q.Name, "a.example.com.", 8080, 60),
+				srvRecord(q.Name, "b.example.com.", 8081, 60))
+			m.Extra = append(m.Extra,
+				aRecord("a.example.com.", "10.0.1.1", 60),
+				aRecord("a.example.com.", "10.0.1.2", 60),
+				aRecord("a.example.com.", "10.0.1.3", 60),
+				aRecord("b.example.com.", "10.0.2.1", 60))
+		}
+		w.WriteMsg(m)
+	})
+	defer stop()
+
+	cfg := &httplb.Config{
+		Type:       httplb.TypeDNS,
+		LBStrategy: httplb.LBWeightedRoundRobin,
+		DNS: &httplb.DnsConfig{
+			Domain:    "_http._tcp.example.com",
+			Type:      httplb.DNSTypeSRV,
+			DNSServer: addr,
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	waitAddrs(t, lb, "10.0.1.1:8080", "10.0.1.2:8080", "10.0.1.3:8080", "10.0.2.1:8081")
+
+	// 两个Target的权重均为10，a的3个地址分得4、3、3
+	want := map[string]uint16{"10.0.1.1": 4, "10.0.1.2": 3, "10.0.1.3": 3, "10.0.2.1": 10}
+	for _, s := range lb.Stats() {
+		if s.Node.Weight != want[s.Node.IP] {
+			t.Fatalf("node %s: expected weight %d, got %d", s.Node.Addr(), want[s.Node.IP], s.Node.Weight)
+		}
+	}
+}
+
+// happy-eyeballs时IPv6地址无法连接则回退到同一域名的IPv4地址
+func TestDNSHappyEyeballsFallback(t *testing.T) {
+	addr, stop := startServer(t, okHandler)
+	defer stop()
+	_, port, _ := net.SplitHostPort(addr)
+	portNum, _ := strconv.Atoi(port)
+
+	dnsAddr, stopDNS := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		switch q.Qtype {
+		case dns.TypeA:
+			m.Answer = append(m.Answer, aRecord(q.Name, "127.0.0.1", 60))
+		case dns.TypeAAAA:
+			// 服务只监听IPv4地址，IPv6地址无法连接
+			m.Answer = append(m.Answer, aaaaRecord(q.Name, "::1", 60))
+		}
+		w.WriteMsg(m)
+	})
+	defer stopDNS()
+
+	cfg := &httplb.Config{
+		Type:       httplb.TypeDNS,
+		LBStrategy: httplb.LBRoundRobin,
+		DNS: &httplb.DnsConfig{
+			Domain:    "svc.example.com",
+			Port:      uint16(portNum),
+			Family:    httplb.DNSFamilyHappyEyeballs,
+			DNSServer: dnsAddr,
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	waitAddrs(t, lb, net.JoinHostPort("::1", port))
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://svc.example.com/")
+	if err := lb.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode())
+	}
+}
+
+// 附加记录中缺少的SRV Target单独解析，无法解析的Target被丢弃；节点携带SRV的Priority
+func TestDNSSRVResolveTargets(t *testing.T) {
+	var targetLookups int32
+	addr, stop := startDNSServer(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		q := r.Question[0]
+		switch {
+		case q.Qtype == dns.TypeSRV:
+			primary := srvRecord(q.Name, "a.example.com.", 8080, 60)
+			backup := srvRecord(q.Name, "B.example.com.", 8081, 60)
+			backup.(*dns.SRV).Priority = 20
+			m.Answer = append(m.Answer, primary, backup, srvRecord(q.Name, "gone.example.com.", 8082, 60))
+			m.Extra = append(m.Extra, aRecord("a.example.com.", "10.0.1.1", 60))
+		case strings.EqualFold(q.Name, "b.example.com.") && q.Qtype == dns.TypeA:
+			atomic.AddInt32(&targetLookups, 1)
+			m.Answer = append(m.Answer, aRecord(q.Name, "10.0.1.2", 60))
+		default:
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+	defer stop()
+
+	cfg := &httplb.Config{
+		Type:       httplb.TypeDNS,
+		LBStrategy: httplb.LBRoundRobin,
+		DNS: &httplb.DnsConfig{
+			Domain:    "_http._tcp.example.com",
+			Type:      httplb.DNSTypeSRV,
+			DNSServer: addr,
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	waitAddrs(t, lb, "10.0.1.1:8080", "10.0.1.2:8081")
+	if atomic.LoadInt32(&targetLookups) == 0 {
+		t.Fatal("target missing from additional section was not resolved")
+	}
+	for _, s := range lb.Stats() {
+		if want := map[string]uint16{"10.0.1.1": 0, "10.0.1.2": 20}[s.Node.IP]; s.Node.Priority != want {
+			t.Fatalf("node %s: expected priority %d, got %d", s.Node.Addr(), want, s.Node.Priority)
+		}
+	}
+	// 只选取优先级最高的一组节点
+	for i := 0; i < 10; i++ {
+		if got := lb.Get().Node().Addr(); got != "10.0.1.1:8080" {
+			t.Fatalf("lower priority node %s selected", got)
+		}
+	}
+}
+
+// DNS首次解析失败时使用配置中的节点列表
+func TestDNSFallbackNodeList(t *testing.T) {
+	cfg := &httplb.Config{
+		Type:       httplb.TypeDNS,
+		LBStrategy: httplb.LBRoundRobin,
+		IPList:     []string{"10.0.0.9:8080"},
+		DNS: &httplb.DnsConfig{
+			Domain:     "svc.example.com",
+			ResolvFile: filepath.Join(os.TempDir(), "httplb-no-such-resolv.conf"),
+			DNSServer:  closedUDPAddr(t),
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan httplb.LoadBalancer, 1)
+	go func() { done <- httplb.New(cfg) }()
+	select {
+	case lb := <-done:
+		defer lb.Close()
+		waitAddrs(t, lb, "10.0.0.9:8080")
+	case <-time.After(3 * time.Second):
+		t.Fatal("New blocked although ip_list is configured")
+	}
+}
@@ -3,8 +3,10 @@ module http-loadbalance
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/go-playground/validator/v10 v10.2.0
 	github.com/hashicorp/consul/api v1.4.0
 	github.com/miekg/dns v1.1.29
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/valyala/fasthttp v1.12.0
 )
@@ -0,0 +1,80 @@
+package httplb
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// ketamaPointsPerNode 平均每个节点在哈希环上的虚拟节点数
+const ketamaPointsPerNode = 160
+
+// hashRing ketama一致性哈希环
+//
+// 每个节点按权重占比分配虚拟节点，虚拟节点由节点地址计算得出，
+// 节点增减时只有约1/N的key会映射到其他节点
+type hashRing struct {
+	cs     []*lbClient
+	points []ringPoint // 按hash升序排列
+}
+
+type ringPoint struct {
+	hash  uint32
+	index int // 对应节点在lbClient列表中的下标
+}
+
+func newHashRing(cs []*lbClient) *hashRing {
+	var totalWeight float64
+	for _, c := range cs {
+		totalWeight += float64(nodeWeight(c.Node()))
+	}
+	points := make([]ringPoint, 0, len(cs)*ketamaPointsPerNode)
+	for i, c := range cs {
+		// 与ketama一致，每个md5摘要生成4个虚拟节点
+		share := float64(nodeWeight(c.Node())) / totalWeight
+		n := int(share * float64(len(cs)*ketamaPointsPerNode/4))
+		if n < 1 {
+			n = 1
+		}
+		addr := c.Node().Addr()
+		for j := 0; j < n; j++ {
+			digest := md5.Sum([]byte(addr + "-" + strconv.Itoa(j)))
+			for k := 0; k < 4; k++ {
+				points = append(points, ringPoint{
+					hash:  binary.LittleEndian.Uint32(digest[k*4:]),
+					index: i,
+				})
+			}
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash
+	})
+	return &hashRing{cs: cs, points: points}
+}
+
+// get 获取key在哈希环上顺时针方向的第一个节点下标
+func (r *hashRing) get(key []byte) int {
+	h := ketamaHash(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].index
+}
+
+func ketamaHash(key []byte) uint32 {
+	digest := md5.Sum(key)
+	return binary.LittleEndian.Uint32(digest[:4])
+}
+
+// nodeWeight 获取节点权重，权重为0时视为1，避免节点完全不可达
+func nodeWeight(node *Node) uint16 {
+	if node.Weight == 0 {
+		return 1
+	}
+	return node.Weight
+}
@@ -0,0 +1,164 @@
+package httplb
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = time.Second
+)
+
+// healthCheckTarget 进行主动健康检查的负载均衡器
+type healthCheckTarget interface {
+	currentConfig() *Config
+	currentClients() []Client
+	// currentRecords 获取各HTTP Client的记录，健康检查的结果保存在其中
+	currentRecords() []*lbClient
+	// refresh 节点健康状态变化时调用，重新选取可用的节点
+	refresh()
+}
+
+// healthChecker 主动健康检查
+//
+// 每次检查时读取负载均衡器当前的配置及Client，UpdateConfig后立即按新的配置检查
+type healthChecker struct {
+	target healthCheckTarget
+	done   <-chan struct{}
+	states map[Client]*probeState
+}
+
+// probeState 节点连续探测成功及失败的次数
+type probeState struct {
+	successes int
+	failures  int
+}
+
+func newHealthChecker(target healthCheckTarget, done <-chan struct{}) *healthChecker {
+	return &healthChecker{
+		target: target,
+		done:   done,
+		states: make(map[Client]*probeState),
+	}
+}
+
+func (h *healthChecker) run() {
+	for {
+		interval := defaultHealthCheckInterval
+		if hc := h.target.currentConfig().HealthCheck; hc != nil {
+			interval = hc.Interval
+			if h.check(hc) {
+				h.target.refresh()
+			}
+		} else if h.reset() {
+			h.target.refresh()
+		}
+
+		select {
+		case <-h.done:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// check 并发探测所有节点，返回是否有节点的健康状态发生变化
+func (h *healthChecker) check(hc *HealthCheckConfig) bool {
+	records := h.target.currentRecords()
+	results := make([]probeResult, len(records))
+	var wg sync.WaitGroup
+	for i, r := range records {
+		wg.Add(1)
+		go func(i int, c Client) {
+			defer wg.Done()
+			results[i] = probe(c, hc)
+		}(i, r.c)
+	}
+	wg.Wait()
+
+	changed := false
+	states := make(map[Client]*probeState, len(records))
+	for i, r := range records {
+		state := h.states[r.c]
+		if state == nil {
+			state = &probeState{}
+		}
+		states[r.c] = state
+
+		switch results[i] {
+		case probeSuccess:
+			state.successes++
+			state.failures = 0
+			if !r.healthy() && state.successes >= hc.Rise {
+				r.setHealthy(true)
+				changed = true
+			}
+		case probeFailure:
+			state.failures++
+			state.successes = 0
+			if r.healthy() && state.failures >= hc.Fall {
+				r.setHealthy(false)
+				changed = true
+			}
+		}
+	}
+	// 已移除的节点不再记录
+	h.states = states
+	return changed
+}
+
+// reset 关闭主动健康检查后恢复所有节点为健康状态，返回是否有节点的健康状态发生变化
+func (h *healthChecker) reset() bool {
+	changed := false
+	for _, r := range h.target.currentRecords() {
+		if !r.healthy() {
+			r.setHealthy(true)
+			changed = true
+		}
+	}
+	h.states = make(map[Client]*probeState)
+	return changed
+}
+
+type probeResult int
+
+const (
+	probeSuccess probeResult = iota
+	probeFailure
+	probeSkipped // Client已关闭或连接池已满，忽略本次结果
+)
+
+// probe 向节点发送一次探测请求
+func probe(c Client, hc *HealthCheckConfig) probeResult {
+	// 不使用fasthttp的对象池，原因见doContext
+	req := &fasthttp.Request{}
+	resp := &fasthttp.Response{}
+	req.Header.SetMethod(hc.Method)
+	req.SetRequestURI("http://" + c.Node().Addr() + hc.Path)
+
+	err := c.DoTimeout(req, resp, hc.Timeout)
+	if err == errClientClosed || err == fasthttp.ErrNoFreeConns {
+		// 探测请求与业务请求共用连接池，连接数达到MaxConns说明节点繁忙，不代表节点不健康
+		return probeSkipped
+	}
+	if err != nil || !expectedStatus(hc.ExpectedStatuses, resp.StatusCode()) {
+		return probeFailure
+	}
+	if hc.Body != "" && !bytes.Contains(resp.Body(), []byte(hc.Body)) {
+		return probeFailure
+	}
+	return probeSuccess
+}
+
+func expectedStatus(statuses []int, code int) bool {
+	for _, s := range statuses {
+		if s == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package httplb_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestActiveHealthCheck(t *testing.T) {
+	var down int32
+	healthHandler := func(down *int32) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			if string(ctx.Path()) == "/health" && atomic.LoadInt32(down) == 1 {
+				ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+				return
+			}
+			ctx.SetBodyString("ok")
+		}
+	}
+	addrA, stopA := startServer(t, healthHandler(new(int32)))
+	defer stopA()
+	addrB, stopB := startServer(t, healthHandler(&down))
+	defer stopB()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addrA, addrB)
+	cfg.HealthCheck = &httplb.HealthCheckConfig{
+		Path:     "/health",
+		Body:     "ok",
+		Interval: 20 * time.Millisecond,
+		Timeout:  500 * time.Millisecond,
+		Rise:     1,
+		Fall:     2,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	nodeHealthy := func(addr string) bool {
+		for _, s := range lb.Stats() {
+			if s.Node.Addr() == addr {
+				return s.Healthy
+			}
+		}
+		t.Fatalf("node %s not found in stats", addr)
+		return false
+	}
+	waitFor := func(cond func() bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for health check")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	onlyA := func() bool {
+		for i := 0; i < 4; i++ {
+			if lb.Get().Node().Addr() != addrA {
+				return false
+			}
+		}
+		return true
+	}
+
+	// 使用同一NodeList但未配置健康检查的负载均衡器不受影响
+	shared := *cfg
+	shared.HealthCheck = nil
+	other := httplb.New(&shared)
+	defer other.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/api")
+	for i := 0; i < 8; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	totalA := func() uint64 {
+		for _, s := range lb.Stats() {
+			if s.Node.Addr() == addrA {
+				return s.Total
+			}
+		}
+		return 0
+	}
+	before := totalA()
+
+	atomic.StoreInt32(&down, 1)
+	waitFor(func() bool { return !nodeHealthy(addrB) })
+	// 节点状态变化后重新选取可用节点
+	waitFor(onlyA)
+	// 节点状态变化不重置负载统计
+	if after := totalA(); after < before {
+		t.Fatalf("stats of %s reset after health change: %d -> %d", addrA, before, after)
+	}
+	for _, s := range other.Stats() {
+		if !s.Healthy {
+			t.Fatalf("health state of %s leaked into another load balancer", s.Node.Addr())
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if addr := lb.Get().Node().Addr(); addr != addrA {
+			t.Fatalf("unhealthy node %s selected", addr)
+		}
+	}
+
+	atomic.StoreInt32(&down, 0)
+	waitFor(func() bool { return nodeHealthy(addrB) })
+	waitFor(func() bool { return !onlyA() })
+}
+
+// 只选取优先级最高的一组节点，该组节点全部不健康时才使用下一优先级的节点
+func TestPriorityFailover(t *testing.T) {
+	var down int32
+	handler := func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/health" && atomic.LoadInt32(&down) == 1 {
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			return
+		}
+		ctx.SetBodyString("ok")
+	}
+	addrA, stopA := startServer(t, handler)
+	defer stopA()
+	addrB, stopB := startServer(t, handler)
+	defer stopB()
+	addrC, stopC := startServer(t, okHandler)
+	defer stopC()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addrA, addrB, addrC)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// A、B为主节点，C为备用节点
+	cfg.NodeList[2].Priority = 10
+	cfg.HealthCheck = &httplb.HealthCheckConfig{
+		Path:     "/health",
+		Interval: 20 * time.Millisecond,
+		Timeout:  500 * time.Millisecond,
+		Rise:     1,
+		Fall:     1,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	selected := func() map[string]bool {
+		addrs := make(map[string]bool)
+		for i := 0; i < 12; i++ {
+			addrs[lb.Get().Node().Addr()] = true
+		}
+		return addrs
+	}
+	waitFor := func(cond func(map[string]bool) bool) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			addrs := selected()
+			if cond(addrs) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected selected nodes %v", addrs)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	if addrs := selected(); len(addrs) != 2 || !addrs[addrA] || !addrs[addrB] {
+		t.Fatalf("expected only primary nodes, got %v", addrs)
+	}
+
+	atomic.StoreInt32(&down, 1)
+	waitFor(func(addrs map[string]bool) bool { return len(addrs) == 1 && addrs[addrC] })
+
+	atomic.StoreInt32(&down, 0)
+	waitFor(func(addrs map[string]bool) bool { return len(addrs) == 2 && addrs[addrA] && addrs[addrB] })
+}
+
+// 连接池被业务请求占满时，探测请求不计为失败
+func TestHealthCheckBusyNode(t *testing.T) {
+	addr, stop := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/slow" {
+			time.Sleep(300 * time.Millisecond)
+		}
+		ctx.SetBodyString("ok")
+	})
+	defer stop()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addr)
+	cfg.Opts.MaxConns = 1
+	cfg.HealthCheck = &httplb.HealthCheckConfig{
+		Path:     "/health",
+		Interval: 20 * time.Millisecond,
+		Timeout:  500 * time.Millisecond,
+		Fall:     1,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+		req.SetRequestURI("http://test/slow")
+		// 探测请求可能先占用唯一的连接
+		err := lb.Do(req, resp)
+		for err == fasthttp.ErrNoFreeConns {
+			time.Sleep(time.Millisecond)
+			err = lb.Do(req, resp)
+		}
+		done <- err
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if s := lb.Stats(); !s[0].Healthy {
+			t.Fatal("busy node marked unhealthy")
+		}
+	}
+}
+
+// Get()返回的Client反映所属负载均衡器的健康检查结果
+func TestClientHealthy(t *testing.T) {
+	var down int32
+	addr, stop := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/health" && atomic.LoadInt32(&down) == 1 {
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			return
+		}
+		ctx.SetBodyString("ok")
+	})
+	defer stop()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addr)
+	cfg.HealthCheck = &httplb.HealthCheckConfig{
+		Path:     "/health",
+		Interval: 20 * time.Millisecond,
+		Timeout:  500 * time.Millisecond,
+		Rise:     1,
+		Fall:     1,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	shared := *cfg
+	shared.HealthCheck = nil
+	other := httplb.New(&shared)
+	defer other.Close()
+
+	if !lb.Get().Healthy() {
+		t.Fatal("expected healthy client")
+	}
+	atomic.StoreInt32(&down, 1)
+	// 唯一的节点不健康时仍被选取，Healthy()返回false
+	deadline := time.Now().Add(5 * time.Second)
+	for lb.Get().Healthy() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for client to become unhealthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !other.Get().Healthy() {
+		t.Fatal("health state leaked into another load balancer")
+	}
+}
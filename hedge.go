@@ -0,0 +1,174 @@
+package httplb
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	defaultHedgeDelay = 100 * time.Millisecond
+
+	// hedgeLatencySamples 计算耗时百分位数时保留的最近请求数
+	hedgeLatencySamples = 512
+	// hedgeMinLatencySamples 样本数少于该值时使用固定的Delay
+	hedgeMinLatencySamples = 32
+	// hedgeRecomputeEvery 每记录多少个样本重新计算一次百分位数
+	hedgeRecomputeEvery = 64
+)
+
+// latencyTracker 记录最近的请求耗时，用于按百分位数计算对冲请求的延迟
+type latencyTracker struct {
+	lock       sync.Mutex
+	samples    [hedgeLatencySamples]time.Duration
+	n          int // 已记录的样本总数
+	percentile float64
+	cached     time.Duration // 按percentile计算的耗时，每hedgeRecomputeEvery个样本更新一次
+}
+
+func (t *latencyTracker) observe(d time.Duration, percentile float64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.samples[t.n%hedgeLatencySamples] = d
+	t.n++
+	if t.n >= hedgeMinLatencySamples && (t.n%hedgeRecomputeEvery == 0 || percentile != t.percentile) {
+		t.recompute(percentile)
+	}
+}
+
+func (t *latencyTracker) recompute(percentile float64) {
+	n := t.n
+	if n > hedgeLatencySamples {
+		n = hedgeLatencySamples
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, t.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(float64(n)*percentile/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= n {
+		i = n - 1
+	}
+	t.percentile = percentile
+	t.cached = sorted[i]
+}
+
+// delay 获取对冲请求的延迟，样本不足时返回fallback
+func (t *latencyTracker) delay(percentile float64, fallback time.Duration) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.n < hedgeMinLatencySamples || t.cached <= 0 {
+		return fallback
+	}
+	if percentile != t.percentile {
+		t.recompute(percentile)
+	}
+	return t.cached
+}
+
+// hedgeDelay 获取发送对冲请求前等待的时间
+func hedgeDelay(config *HedgeConfig, latency *latencyTracker) time.Duration {
+	if config.Percentile > 0 {
+		return latency.delay(config.Percentile, config.Delay)
+	}
+	return config.Delay
+}
+
+type hedgeResult struct {
+	resp *fasthttp.Response
+	err  error
+}
+
+// succeeded 请求成功且响应不是5xx时胜出
+func (r hedgeResult) succeeded() bool {
+	return r.err == nil && r.resp.StatusCode() < fasthttp.StatusInternalServerError
+}
+
+// doHedged 发送对冲请求
+//
+// 第一个请求在延迟时间内未返回时，在预算充足的情况下向另一个节点发送相同的请求，最先成功的响应写入resp，
+// 另一个请求通过ctx取消，其结果被丢弃。两个请求都失败时返回先完成的请求的结果。
+// 节点的Client已关闭时请求未发出，立即在其他节点上重新发送，不消耗预算。
+// 每个请求使用req的独立副本，避免并发请求共用请求缓冲区
+func doHedged(lb *balancer, config *HedgeConfig, ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error {
+	if lb.isClosed() {
+		return ErrLBClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	budgets := lb.requestBudgets()
+	budget, latency := &budgets.hedge, &budgets.latency
+	budget.deposit()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// 最多发送2个请求，另有maxPickAttempts次Client已关闭时的重新发送
+	results := make(chan hedgeResult, 2+maxPickAttempts)
+	launch := func(c *lbClient) {
+		// 不使用fasthttp的对象池，原因见doContext
+		reqCopy := &fasthttp.Request{}
+		req.CopyTo(reqCopy)
+		respCopy := &fasthttp.Response{}
+		respCopy.SkipBody = resp.SkipBody
+		go func() {
+			start := time.Now()
+			err := c.DoContext(ctx, reqCopy, respCopy)
+			if err == nil {
+				latency.observe(time.Since(start), config.Percentile)
+			}
+			results <- hedgeResult{resp: respCopy, err: err}
+		}()
+	}
+
+	first := lb.pick(req)
+	if first == nil {
+		return ErrNoNodes
+	}
+	launch(first)
+	tried := []Client{first.c}
+	pending, resent := 1, 0
+	timer := time.NewTimer(hedgeDelay(config, latency))
+	defer timer.Stop()
+
+	var failed *hedgeResult
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.succeeded() {
+				r.resp.CopyTo(resp)
+				return nil
+			}
+			if r.err == errClientClosed && resent < maxPickAttempts {
+				if c := pickUntried(lb, req, tried); c != nil {
+					resent++
+					tried = append(tried, c.c)
+					launch(c)
+					pending++
+					continue
+				}
+			}
+			if failed == nil {
+				failed = &r
+			}
+		case <-timer.C:
+			// 先选取节点，没有其他节点可用时不消耗预算
+			c := pickUntried(lb, req, tried)
+			if c == nil || !budget.withdraw(config.BudgetRatio, config.BudgetMinPerSecond) {
+				continue
+			}
+			tried = append(tried, c.c)
+			launch(c)
+			pending++
+		}
+	}
+	if failed.err == nil {
+		failed.resp.CopyTo(resp)
+	}
+	return failed.err
+}
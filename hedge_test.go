@@ -0,0 +1,108 @@
+package httplb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestHedgedRequests(t *testing.T) {
+	slowAddr, stopSlow := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(500 * time.Millisecond)
+		ctx.SetBodyString("slow")
+	})
+	defer stopSlow()
+	fastAddr, stopFast := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString("fast")
+	})
+	defer stopFast()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, slowAddr, fastAddr)
+	cfg.Opts.MaxConns = 16
+	cfg.Hedge = &httplb.HedgeConfig{Delay: 20 * time.Millisecond}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + fastAddr + "/")
+
+	for i := 0; i < 6; i++ {
+		start := time.Now()
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+			t.Fatalf("request %d took %s, hedge did not win", i, elapsed)
+		}
+		if body := string(resp.Body()); body != "fast" {
+			t.Fatalf("request %d got body %q", i, body)
+		}
+	}
+
+	// 非幂等请求不发送对冲请求
+	req.Header.SetMethod(fasthttp.MethodPost)
+	slow := 0
+	for i := 0; i < 2; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Body()) == "slow" {
+			slow++
+		}
+	}
+	if slow != 1 {
+		t.Fatalf("expected one POST to wait for the slow node, got %d", slow)
+	}
+}
+
+// 对冲请求中被取消的一方不计入断路器及惩罚
+func TestHedgeLoserNotPenalized(t *testing.T) {
+	slowAddr, stopSlow := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(200 * time.Millisecond)
+		ctx.SetBodyString("slow")
+	})
+	defer stopSlow()
+	fastAddr, stopFast := startServer(t, okHandler)
+	defer stopFast()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, slowAddr, fastAddr)
+	cfg.Opts.MaxConns = 16
+	cfg.Hedge = &httplb.HedgeConfig{Delay: 20 * time.Millisecond}
+	cfg.CircuitBreaker = &httplb.CircuitBreakerConfig{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Minute,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + fastAddr + "/")
+
+	for i := 0; i < 4; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 等待被取消的请求完成
+	time.Sleep(300 * time.Millisecond)
+	for _, s := range lb.Stats() {
+		if s.Circuit != httplb.CircuitClosed || s.Penalty != 0 {
+			t.Fatalf("hedge loser %s penalized: circuit %s, penalty %d", s.Node.Addr(), s.Circuit, s.Penalty)
+		}
+	}
+}
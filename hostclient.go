@@ -1,15 +1,32 @@
 package httplb
 
 import (
+	"context"
+	"errors"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
 
+var (
+	defaultDrainTimeout = time.Second * 10 // 关闭Client时等待进行中请求完成的最长时间
+
+	// errClientClosed Client已关闭（如节点被服务发现移除），请求未发出，负载均衡器会重新选取其他节点发送
+	errClientClosed = errors.New("httplb: client is closed")
+)
+
 // HostClient 负载均衡器使用的HTTP客户端
 type HostClient struct {
 	fasthttp.HostClient
 	node *Node
+
+	closed   int32
+	connLock sync.Mutex
+	conns    map[*trackedConn]struct{} // 当前打开的连接，Close()时统一关闭
 }
 
 // Name 获取客户端名称，根据节点信息IP:Port_Weight拼接而成
@@ -22,24 +39,219 @@ func (c *HostClient) Node() *Node {
 	return c.node
 }
 
+// Healthy HostClient本身不做健康检查，未关闭时返回true；负载均衡器返回的Client反映其健康检查结果
+func (c *HostClient) Healthy() bool {
+	return !c.isClosed()
+}
+
+// Do 发送请求，Client关闭后返回errClientClosed
+func (c *HostClient) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	if c.isClosed() {
+		return errClientClosed
+	}
+	return c.HostClient.Do(req, resp)
+}
+
+// DoTimeout 发送请求并在timeout后超时，Client关闭后返回errClientClosed
+func (c *HostClient) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	if c.isClosed() {
+		return errClientClosed
+	}
+	return c.HostClient.DoTimeout(req, resp, timeout)
+}
+
+// DoDeadline 发送请求并在deadline时超时，Client关闭后返回errClientClosed
+func (c *HostClient) DoDeadline(req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
+	if c.isClosed() {
+		return errClientClosed
+	}
+	return c.HostClient.DoDeadline(req, resp, deadline)
+}
+
+// DoContext 发送请求，请求受ctx的截止时间及取消控制，包括等待空闲连接的时间，Client关闭后返回errClientClosed
+func (c *HostClient) DoContext(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error {
+	if c.isClosed() {
+		return errClientClosed
+	}
+	do := c.HostClient.Do
+	if deadline, ok := ctx.Deadline(); ok {
+		// ctx到达截止时间后，仍在等待空闲连接的后台请求副本同样放弃，不会在之后发出
+		do = func(req *fasthttp.Request, resp *fasthttp.Response) error {
+			err := c.HostClient.DoDeadline(req, resp, deadline)
+			if err == fasthttp.ErrTimeout && !time.Now().Before(deadline) {
+				// 与ctx同时到达截止时间，按ctx的错误返回
+				err = context.DeadlineExceeded
+			}
+			return err
+		}
+	}
+	return doContext(ctx, req, resp, do)
+}
+
+// Close 拒绝新的请求，等待进行中的请求完成（最长defaultDrainTimeout）后关闭所有连接
+//
+// fasthttp内部的连接清理协程会在MaxIdleConnDuration之后发现连接已全部关闭并退出
+func (c *HostClient) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
+	deadline := time.Now().Add(defaultDrainTimeout)
+	for c.HostClient.PendingRequests() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	c.connLock.Lock()
+	conns := c.conns
+	c.conns = nil
+	c.connLock.Unlock()
+	for conn := range conns {
+		_ = conn.Conn.Close()
+	}
+	return nil
+}
+
+func (c *HostClient) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// dial 建立连接并记录，以便Close()时能够关闭连接池中的空闲连接
+func (c *HostClient) dial(addr string, timeout time.Duration) (net.Conn, error) {
+	if c.isClosed() {
+		return nil, errClientClosed
+	}
+	dialAddr := func(addr string) (net.Conn, error) {
+		// fasthttp.Dial只支持IPv4，节点可能为IPv6地址
+		if timeout > 0 {
+			return fasthttp.DialDualStackTimeout(addr, timeout)
+		}
+		// 未配置connect_timeout时使用fasthttp默认的连接超时时间
+		return fasthttp.DialDualStack(addr)
+	}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if len(c.node.fallback) > 0 {
+		addrs := []string{addr}
+		port := strconv.Itoa(int(c.node.Port))
+		for _, ip := range c.node.fallback {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+		conn, err = dialHappyEyeballs(addrs, dialAddr)
+	} else {
+		conn, err = dialAddr(addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tc := &trackedConn{Conn: conn, c: c}
+	c.connLock.Lock()
+	if c.isClosed() {
+		c.connLock.Unlock()
+		_ = conn.Close()
+		return nil, errClientClosed
+	}
+	if c.conns == nil {
+		c.conns = make(map[*trackedConn]struct{})
+	}
+	c.conns[tc] = struct{}{}
+	c.connLock.Unlock()
+	return tc, nil
+}
+
+// happyEyeballsDelay 前一个地址的连接在该时间内未建立时发起下一个地址的连接，见RFC 8305的Connection Attempt Delay
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// dialHappyEyeballs 依次向addrs发起连接，前一个连接失败或happyEyeballsDelay内未建立时即发起下一个，
+// 返回最先建立的连接并关闭之后建立的连接，全部失败时返回第一个错误
+func dialHappyEyeballs(addrs []string, dial func(addr string) (net.Conn, error)) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	var (
+		next     int
+		pending  int
+		firstErr error
+		delay    <-chan time.Time
+	)
+	start := func() {
+		addr := addrs[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dial(addr)
+			results <- result{conn, err}
+		}()
+		if next < len(addrs) {
+			delay = time.After(happyEyeballsDelay)
+		} else {
+			delay = nil
+		}
+	}
+	start()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(n int) {
+					for i := 0; i < n; i++ {
+						if late := <-results; late.conn != nil {
+							_ = late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+			}
+		case <-delay:
+			start()
+		}
+	}
+	return nil, firstErr
+}
+
+func (c *HostClient) untrack(tc *trackedConn) {
+	c.connLock.Lock()
+	delete(c.conns, tc)
+	c.connLock.Unlock()
+}
+
+// trackedConn 关闭时从HostClient的连接记录中移除
+type trackedConn struct {
+	net.Conn
+	c *HostClient
+}
+
+func (tc *trackedConn) Close() error {
+	tc.c.untrack(tc)
+	return tc.Conn.Close()
+}
+
 // NewHostClient 创建HTTP Client客户端
 func NewHostClient(node *Node, opts *Opts) Client {
-	c := HostClient{
-		HostClient: fasthttp.HostClient{
-			Addr: node.Addr(),
-			Name: node.String(),
-			Dial: func(addr string) (net.Conn, error) {
-				return fasthttp.DialTimeout(addr, opts.ConnectTimeout)
-			},
-			IsTLS:                     opts.IsTLS,
-			MaxConns:                  opts.MaxConns,
-			MaxConnDuration:           opts.MaxConnDuration,
-			MaxIdleConnDuration:       opts.MaxIdleConnDuration,
-			MaxIdemponentCallAttempts: opts.MaxCallAttempts,
-			ReadTimeout:               opts.ReadTimeout,
-			WriteTimeout:              opts.WriteTimeout,
+	c := &HostClient{node: node}
+	c.HostClient = fasthttp.HostClient{
+		Addr: node.Addr(),
+		Name: node.String(),
+		Dial: func(addr string) (net.Conn, error) {
+			return c.dial(addr, opts.ConnectTimeout)
 		},
-		node: node,
+		IsTLS:                     opts.IsTLS,
+		MaxConns:                  opts.MaxConns,
+		MaxConnDuration:           opts.MaxConnDuration,
+		MaxIdleConnDuration:       opts.MaxIdleConnDuration,
+		MaxIdemponentCallAttempts: opts.MaxCallAttempts,
+		MaxConnWaitTimeout:        opts.MaxConnWaitTimeout,
+		ReadTimeout:               opts.ReadTimeout,
+		WriteTimeout:              opts.WriteTimeout,
 	}
-	return &c
+	return c
 }
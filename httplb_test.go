@@ -1,7 +1,6 @@
 package httplb_test
 
 import (
-	"fmt"
 	"testing"
 	"time"
 
@@ -42,14 +41,18 @@ var (
 	}
 )
 
+// TestHTTPLB 向本地127.0.0.1:7780、7781发送请求并打印结果，未启动服务时仅打印连接错误
 func TestHTTPLB(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
 	var err error
 	if err = cfg.Validate(); err != nil {
 		t.Fatal(err)
 	}
 	lb := httplb.New(&cfg)
-	i := 0
-	for {
+	defer lb.Close()
+	for i := 0; i < 100; i++ {
 		time.Sleep(time.Millisecond * 10)
 		req := fasthttp.Request{}
 		req.SetRequestURI("http://test/api")
@@ -58,11 +61,10 @@ func TestHTTPLB(t *testing.T) {
 		err = c.Do(&req, &resp)
 
 		if err != nil {
-			fmt.Println(i, "name:", c.Name(), " error:", err)
+			t.Log(i, "name:", c.Name(), " error:", err)
 		} else {
-			fmt.Println(i, "name:", c.Name(), " response:", resp.StatusCode())
+			t.Log(i, "name:", c.Name(), " response:", resp.StatusCode())
 		}
-		i++
 	}
 }
 
@@ -0,0 +1,70 @@
+package httplb_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestIPv6StaticNode(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("ipv6 loopback unavailable: %v", err)
+	}
+	s := &fasthttp.Server{Handler: okHandler}
+	go func() { _ = s.Serve(ln) }()
+	defer s.Shutdown()
+
+	addr := ln.Addr().String()
+	cfg := newStaticConfig(httplb.LBRoundRobin, addr+" weight=5")
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if n := cfg.NodeList[0]; n.IP != "::1" || n.Weight != 5 || n.Addr() != addr {
+		t.Fatalf("unexpected node %+v, addr %s", n, n.Addr())
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + addr + "/")
+	if err := lb.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode())
+	}
+}
+
+func TestIPv6IPList(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBRoundRobin, "[2001:db8::1]", "[2001:db8::2]:9090 weight=3", "10.0.0.1:8080")
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"[2001:db8::1]:80", "[2001:db8::2]:9090", "10.0.0.1:8080"}
+	for i, n := range cfg.NodeList {
+		if n.Addr() != want[i] {
+			t.Fatalf("node %d: expected %s, got %s", i, want[i], n.Addr())
+		}
+	}
+	if cfg.NodeList[1].Weight != 3 {
+		t.Fatalf("unexpected weight %d", cfg.NodeList[1].Weight)
+	}
+
+	for _, bad := range []string{"[10.0.0.1]:8080", "[zz::1]:8080", "2001:db8::1", "2001:db8::1:8080", "10.0.0.1:8080x", "10.0.0.1:8080 weight=3 extra"} {
+		if err := newStaticConfig(httplb.LBRoundRobin, bad).Validate(); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+	err := newStaticConfig(httplb.LBRoundRobin, "2001:db8::1:8080").Validate()
+	if err == nil || !strings.Contains(err.Error(), "[addr]:port") {
+		t.Fatalf("expected bracket hint for unbracketed IPv6, got %v", err)
+	}
+}
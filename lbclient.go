@@ -1,40 +1,112 @@
 package httplb
 
 import (
+	"context"
 	"sync/atomic"
 	"time"
 
 	"github.com/valyala/fasthttp"
 )
 
+// lbClient 负载均衡器中单个HTTP Client的记录，保存该节点的负载统计及健康、移出、熔断状态
+//
+// 由balancer按Client创建，节点列表或节点状态变化时复用，只在Client被移除后丢弃
 type lbClient struct {
-	c           Client
-	healthCheck func(req *fasthttp.Request, resp *fasthttp.Response, err error) bool
-	penalty     uint32
+	c Client
+	// lb 所属的负载均衡器，请求完成后调用其HealthCheck或HealthCheckContext回调
+	lb      *balancer
+	penalty uint32
+
+	// unhealthy 主动健康检查的结果，非0表示不健康
+	unhealthy int32
 
 	// total amount of requests handled.
 	total uint64
+
+	// latency 请求耗时的指数加权移动平均值，单位纳秒
+	latency int64
+
+	// outlier 节点的异常检测统计
+	outlier *outlierState
+
+	// breaker 节点的断路器
+	breaker *circuitBreaker
+
+	// peak 按时间衰减的峰值平均耗时（*peakEWMA），LBPeakEWMA策略第一次选取该节点前设置，
+	// 切换到其他策略后保留并继续记录，其他策略下未设置
+	peak atomic.Value
 }
 
 func (c *lbClient) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	if !c.acquire() {
+		return ErrCircuitOpen
+	}
+	start := time.Now()
 	err := c.c.Do(req, resp)
-	c.panalty(req, resp, err)
-	return err
+	return c.finish(context.Background(), req, resp, start, err)
 }
 func (c *lbClient) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	if !c.acquire() {
+		return ErrCircuitOpen
+	}
+	start := time.Now()
 	err := c.c.DoTimeout(req, resp, timeout)
-	c.panalty(req, resp, err)
-	return err
+	return c.finish(context.Background(), req, resp, start, err)
 }
 
 func (c *lbClient) DoDeadline(req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
+	if !c.acquire() {
+		return ErrCircuitOpen
+	}
+	start := time.Now()
 	err := c.c.DoDeadline(req, resp, deadline)
-	c.panalty(req, resp, err)
+	return c.finish(context.Background(), req, resp, start, err)
+}
+
+func (c *lbClient) DoContext(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error {
+	if !c.acquire() {
+		return ErrCircuitOpen
+	}
+	start := time.Now()
+	err := c.c.DoContext(ctx, req, resp)
+	return c.finish(ctx, req, resp, start, err)
+}
+
+// finish 请求完成后记录耗时及结果
+//
+// Client已关闭（节点已被移除）时请求未发出，不记录耗时及结果，由调用方重新选取节点；负载均衡器已关闭时返回ErrLBClosed
+func (c *lbClient) finish(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, start time.Time, err error) error {
+	if err == errClientClosed {
+		if c.breaker != nil {
+			c.breaker.release()
+		}
+		if c.lb.isClosed() {
+			return ErrLBClosed
+		}
+		return err
+	}
+	c.observe(start)
+	c.panalty(ctx, req, resp, err)
 	return err
 }
 
-func (c *lbClient) panalty(req *fasthttp.Request, resp *fasthttp.Response, err error) {
-	if !c.isHealthy(req, resp, err) && c.incPenalty() {
+func (c *lbClient) panalty(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, err error) {
+	if err == context.Canceled || ctx.Err() == context.Canceled {
+		// 调用方取消的请求（如对冲请求中落败的一方）不反映节点状态，不计入异常检测、断路器及惩罚；
+		// 到达截止时间的请求仍按节点超时处理
+		if c.breaker != nil {
+			c.breaker.release()
+		}
+		return
+	}
+	if c.outlier != nil {
+		c.outlier.record(resp, err)
+	}
+	healthy := c.isHealthy(ctx, req, resp, err)
+	if c.breaker != nil {
+		c.breaker.record(healthy)
+	}
+	if !healthy && c.incPenalty() {
 		// Penalize the client returning error, so the next requests
 		// are routed to another clients.
 		time.AfterFunc(penaltyDuration, c.decPenalty)
@@ -43,6 +115,46 @@ func (c *lbClient) panalty(req *fasthttp.Request, resp *fasthttp.Response, err e
 	}
 }
 
+// acquire 断路器允许时返回true
+func (c *lbClient) acquire() bool {
+	return c.breaker == nil || c.breaker.acquire()
+}
+
+// peakEWMA 获取节点的峰值平均耗时统计，未设置时返回nil
+func (c *lbClient) peakEWMA() *peakEWMA {
+	e, _ := c.peak.Load().(*peakEWMA)
+	return e
+}
+
+// ready 判断断路器是否允许选择该节点
+func (c *lbClient) ready() bool {
+	return c.breaker == nil || c.breaker.ready()
+}
+
+// observe 记录本次请求的耗时，按权重latencyEWMAWeight更新平均耗时
+func (c *lbClient) observe(start time.Time) {
+	d := time.Since(start)
+	if e := c.peakEWMA(); e != nil {
+		e.observe(d)
+	}
+	rtt := int64(d)
+	for {
+		old := atomic.LoadInt64(&c.latency)
+		v := rtt
+		if old > 0 {
+			v = old + (rtt-old)/latencyEWMAWeight
+		}
+		if atomic.CompareAndSwapInt64(&c.latency, old, v) {
+			return
+		}
+	}
+}
+
+// Latency 获取请求的平均耗时
+func (c *lbClient) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.latency))
+}
+
 func (c *lbClient) PendingRequests() int {
 	n := c.c.PendingRequests()
 	m := atomic.LoadUint32(&c.penalty)
@@ -56,11 +168,48 @@ func (c *lbClient) Name() string {
 func (c *lbClient) Node() *Node {
 	return c.c.Node()
 }
-func (c *lbClient) isHealthy(req *fasthttp.Request, resp *fasthttp.Response, err error) bool {
-	if c.healthCheck == nil {
-		return err == nil
+
+// isHealthy 按负载均衡器的HealthCheckContext、HealthCheck回调或配置中的同名回调判断请求是否健康，都未设置时err为nil即健康
+func (c *lbClient) isHealthy(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, err error) bool {
+	if c.lb.HealthCheckContext != nil {
+		return c.lb.HealthCheckContext(ctx, req, resp, err)
+	}
+	if c.lb.HealthCheck != nil {
+		return c.lb.HealthCheck(req, resp, err)
+	}
+	if s := c.lb.cs.snapshot(); s != nil {
+		if s.config.HealthCheckContextFunc != nil {
+			return s.config.HealthCheckContextFunc(ctx, req, resp, err)
+		}
+		if s.config.HealthCheckFunc != nil {
+			return s.config.HealthCheckFunc(req, resp, err)
+		}
+	}
+	return err == nil
+}
+
+// Healthy 获取节点是否通过主动健康检查，未配置主动健康检查时始终为true
+//
+// 健康状态属于负载均衡器，使用同一NodeList的其他负载均衡器不受影响
+func (c *lbClient) Healthy() bool {
+	return c.healthy()
+}
+
+func (c *lbClient) healthy() bool {
+	return atomic.LoadInt32(&c.unhealthy) == 0
+}
+
+func (c *lbClient) setHealthy(healthy bool) {
+	var v int32
+	if !healthy {
+		v = 1
 	}
-	return c.healthCheck(req, resp, err)
+	atomic.StoreInt32(&c.unhealthy, v)
+}
+
+// available 判断节点能否参与选择，排除未通过主动健康检查、被移出及断路器打开的节点
+func (c *lbClient) available() bool {
+	return c.healthy() && !c.outlier.isEjected() && c.breaker.currentState() != CircuitOpen
 }
 
 func (c *lbClient) incPenalty() bool {
@@ -76,8 +225,52 @@ func (c *lbClient) decPenalty() {
 	atomic.AddUint32(&c.penalty, ^uint32(0))
 }
 
+// NodeStats 节点的负载统计
+type NodeStats struct {
+	Node            *Node
+	Healthy         bool          // 是否通过主动健康检查
+	Ejected         bool          // 是否被异常检测移出
+	Circuit         CircuitState  // 断路器状态
+	PendingRequests int           // 进行中的请求数
+	Total           uint64        // 已处理的健康请求总数
+	Penalty         uint32        // 当前的惩罚值，请求失败时增加，penaltyDuration后恢复
+	Latency         time.Duration // 请求的平均耗时
+}
+
+// clientStats 获取各节点的负载统计，cs为可选的节点，records中未通过主动健康检查、被移出及断路器打开的节点也包含在内
+func clientStats(cs []*lbClient, records []*lbClient) []NodeStats {
+	stats := make([]NodeStats, 0, len(records))
+	selectable := make(map[*lbClient]bool, len(cs))
+	for _, c := range cs {
+		selectable[c] = true
+		stats = append(stats, c.stats())
+	}
+	for _, c := range records {
+		if !selectable[c] {
+			stats = append(stats, c.stats())
+		}
+	}
+	return stats
+}
+
+func (c *lbClient) stats() NodeStats {
+	return NodeStats{
+		Node:            c.Node(),
+		Healthy:         c.healthy(),
+		Ejected:         c.outlier.isEjected(),
+		Circuit:         c.breaker.currentState(),
+		PendingRequests: c.c.PendingRequests(),
+		Total:           atomic.LoadUint64(&c.total),
+		Penalty:         atomic.LoadUint32(&c.penalty),
+		Latency:         c.Latency(),
+	}
+}
+
 const (
 	maxPenalty = 300
 
 	penaltyDuration = time.Second
+
+	// latencyEWMAWeight 每次请求的耗时在平均耗时中的权重为1/latencyEWMAWeight
+	latencyEWMAWeight = 8
 )
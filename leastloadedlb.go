@@ -1,7 +1,6 @@
 package httplb
 
 import (
-	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,124 +19,34 @@ import (
 //
 // It is safe calling LeastLoadedLB methods from concurrently running goroutines.
 type LeastLoadedLB struct {
-
-	// clients must contain non-zero clients list.
-	// Incoming requests are balanced among these clients.
-	clients []Client
-	config  *Config // 记录配置文件
-
-	// HealthCheck is a callback called after each request.
-	//
-	// The request, response and the error returned by the client
-	// is passed to HealthCheck, so the callback may determine whether
-	// the client is healthy.
-	//
-	// Load on the current client is decreased if HealthCheck returns false.
-	//
-	// By default HealthCheck returns false if err != nil.
-	HealthCheck func(req *fasthttp.Request, resp *fasthttp.Response, err error) bool
-
-	// Timeout is the request timeout used when calling LeastLoadedLB.Do.
-	//
-	// DefaultLBClientTimeout is used by default.
-	Timeout time.Duration
-
-	cs []*lbClient
-
-	once sync.Once
-
-	watcher *watcher
-
-	lock sync.RWMutex
+	*balancer
 }
 
 // NewLeastLB 创建最小连接数负载均衡器
 func NewLeastLB(config *Config) *LeastLoadedLB {
-	clients := createClients(config.NodeList, config.Opts)
-	w := newWatcher(config)
-
-	lb := LeastLoadedLB{
-		watcher: w,
-		config:  config,
-		clients: clients,
-		Timeout: config.Opts.ReadTimeout * 2, // 默认负载均衡器超时时间是配置中read_timeout的2倍
-	}
-	lb.fetchOnce()
-	go lb.watch()
-	return &lb
+	return newLeastLB(config, nil)
 }
 
-func (cc *LeastLoadedLB) watch() {
-	for {
-		time.Sleep(time.Second * 5)
-		nodes := cc.watcher.watch(cc.config)
-		newClients, isUpdate := updateClients(cc.clients, nodes, cc.config.Opts)
-		if !isUpdate {
-			continue
-		}
-		cc.lock.Lock()
-		cc.clients = newClients
-		cc.init()
-		cc.lock.Unlock()
+// newLeastLB 创建负载均衡器，core为切换负载均衡策略前的balancer，为nil时创建新的balancer
+func newLeastLB(config *Config, core *balancer) *LeastLoadedLB {
+	lb := &LeastLoadedLB{}
+	lb.balancer = newBalancer(lb, config, core)
+	if core == nil {
+		lb.Timeout = config.Opts.ReadTimeout * 2 // 默认负载均衡器超时时间是配置中read_timeout的2倍
 	}
+	return lb
 }
 
-func (cc *LeastLoadedLB) fetchOnce() {
-	nodes := cc.watcher.watch(cc.config)
-	newClients, _ := updateClients(cc.clients, nodes, cc.config.Opts)
-	cc.lock.Lock()
-	cc.clients = newClients
-	cc.init()
-	cc.lock.Unlock()
-}
-
-// DefaultLBClientTimeout is the default request timeout used by LeastLoadedLB
-// when calling LeastLoadedLB.Do.
+// DefaultLBClientTimeout is the default request timeout used by load balancers
+// when calling Do.
 //
-// The timeout may be overridden via LeastLoadedLB.Timeout.
+// The timeout may be overridden via Opts.RequestTimeout or the Timeout field
+// of each load balancer. LeastLoadedLB defaults Timeout to Opts.ReadTimeout * 2.
 const DefaultLBClientTimeout = time.Second * 2
 
-// DoDeadline calls DoDeadline on the least loaded client
-func (cc *LeastLoadedLB) DoDeadline(req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
-	return cc.get().DoDeadline(req, resp, deadline)
-}
-
-// DoTimeout calculates deadline and calls DoDeadline on the least loaded client
-func (cc *LeastLoadedLB) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
-	return cc.get().DoTimeout(req, resp, timeout)
-}
-
-// Do calls calculates deadline using LeastLoadedLB.Timeout and calls DoDeadline
-// on the least loaded client.
-func (cc *LeastLoadedLB) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
-	return cc.get().Do(req, resp)
-}
-
-func (cc *LeastLoadedLB) Get() Client {
-	return cc.get()
-}
-
-func (cc *LeastLoadedLB) init() {
-	if len(cc.clients) == 0 {
-		panic("BUG: LeastLoadedLB.clients cannot be empty")
-	}
-	cs := make([]*lbClient, 0, len(cc.clients))
-	for _, c := range cc.clients {
-		cs = append(cs, &lbClient{
-			c:           c,
-			healthCheck: cc.HealthCheck,
-		})
-	}
-	cc.cs = cs
-}
-
-func (cc *LeastLoadedLB) get() *lbClient {
-
-	cc.lock.RLock()
-	defer cc.lock.RUnlock()
-
-	cs := cc.cs
+func (cc *LeastLoadedLB) update(cs []*lbClient, config *Config) {}
 
+func (cc *LeastLoadedLB) choose(cs []*lbClient, req *fasthttp.Request) *lbClient {
 	minC := cs[0]
 	minN := minC.PendingRequests()
 	minT := atomic.LoadUint64(&minC.total)
@@ -0,0 +1,42 @@
+package httplb
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// lifecycle 负载均衡器的生命周期控制
+//
+// 负责通知watch协程退出，并等待其结束，以便Close()之后不再创建新的HTTP Client
+type lifecycle struct {
+	closed int32
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newLifecycle() lifecycle {
+	return lifecycle{done: make(chan struct{})}
+}
+
+// isClosed 判断负载均衡器是否已关闭
+func (l *lifecycle) isClosed() bool {
+	return atomic.LoadInt32(&l.closed) == 1
+}
+
+// shutdown 标记为关闭状态并通知watch协程退出，重复调用时返回false
+func (l *lifecycle) shutdown() bool {
+	if !atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+		return false
+	}
+	close(l.done)
+	return true
+}
+
+// goWatch 启动watch协程，Close()时会等待该协程退出
+func (l *lifecycle) goWatch(f func()) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		f()
+	}()
+}
@@ -0,0 +1,166 @@
+package httplb_test
+
+import (
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+// startServer 启动本地HTTP服务，返回其"IP:Port"地址及关闭函数
+func startServer(t testing.TB, handler fasthttp.RequestHandler) (string, func()) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fasthttp.Server{Handler: handler}
+	go func() { _ = s.Serve(ln) }()
+	return ln.Addr().String(), func() { _ = s.Shutdown() }
+}
+
+func okHandler(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
+
+func newStaticConfig(strategy httplb.LBStrategy, ipList ...string) *httplb.Config {
+	return &httplb.Config{
+		LBStrategy: strategy,
+		Type:       httplb.TypeStatic,
+		IPList:     ipList,
+		Opts: &httplb.Opts{
+			ConnectTimeout: time.Second,
+			ReadTimeout:    time.Second,
+			WriteTimeout:   time.Second,
+			// fasthttp的连接清理协程在空闲超时后才会退出
+			MaxIdleConnDuration: time.Millisecond * 100,
+		},
+	}
+}
+
+func TestClose(t *testing.T) {
+	addr, stop := startServer(t, okHandler)
+	defer stop()
+	strategies := []httplb.LBStrategy{httplb.LBRoundRobin, httplb.LBRandom, httplb.LBWeightedRoundRobin, httplb.LBLeastConnection}
+	for _, strategy := range strategies {
+		cfg := newStaticConfig(strategy, addr)
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		lb := httplb.New(cfg)
+
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		req.SetRequestURI("http://test/api")
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatalf("strategy %d: unexpected error: %v", strategy, err)
+		}
+		c := lb.Get()
+		if err := lb.Close(); err != nil {
+			t.Fatalf("strategy %d: close error: %v", strategy, err)
+		}
+		if err := lb.Do(req, resp); err != httplb.ErrLBClosed {
+			t.Fatalf("strategy %d: expected ErrLBClosed, got %v", strategy, err)
+		}
+		if err := c.Do(req, resp); err != httplb.ErrLBClosed {
+			t.Fatalf("strategy %d: expected ErrLBClosed from client, got %v", strategy, err)
+		}
+		if err := lb.Close(); err != nil {
+			t.Fatalf("strategy %d: second close error: %v", strategy, err)
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}
+
+	// watch协程及Client相关协程都应已退出
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		buf := make([]byte, 1<<20)
+		stack := string(buf[:runtime.Stack(buf, true)])
+		if !strings.Contains(stack, "http-loadbalance.(") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked:\n%s", stack)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+var (
+	stalePicked       = make(chan string)
+	staleRelease      = make(chan struct{})
+	testStaleStrategy = httplb.RegisterStrategy("test_stale", func(config *httplb.Config) httplb.Picker {
+		return &stalePicker{}
+	})
+)
+
+// stalePicker 第一次为请求选取节点后等待staleRelease再返回，模拟选取节点后该节点被服务发现移除
+type stalePicker struct {
+	blocked int32
+}
+
+func (p *stalePicker) Pick(nodes []httplb.Client, req *fasthttp.Request) httplb.Client {
+	c := nodes[0]
+	if req != nil && atomic.CompareAndSwapInt32(&p.blocked, 0, 1) {
+		stalePicked <- c.Node().Addr()
+		<-staleRelease
+	}
+	return c
+}
+
+// 选取的节点在发送前被移除时，请求在其他节点上发送，非幂等请求同样如此
+func TestRequestOnRemovedNode(t *testing.T) {
+	nameHandler := func(name string) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			ctx.SetBodyString(name)
+		}
+	}
+	addrA, stopA := startServer(t, nameHandler("a"))
+	defer stopA()
+	addrB, stopB := startServer(t, nameHandler("b"))
+	defer stopB()
+
+	cfg := newStaticConfig(testStaleStrategy, addrA, addrB)
+	cfg.Type = "test_registry"
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	r := <-testRegistries
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.SetRequestURI("http://test/api")
+	errCh := make(chan error, 1)
+	go func() { errCh <- lb.Do(req, resp) }()
+
+	keep, want := addrB, "b"
+	if <-stalePicked == addrB {
+		keep, want = addrA, "a"
+	}
+	host, port, _ := net.SplitHostPort(keep)
+	p, _ := strconv.Atoi(port)
+	r.push(&httplb.Node{IP: host, Port: uint16(p), Weight: 100})
+	waitAddrs(t, lb, keep)
+	// 被移除的Client在后台关闭
+	time.Sleep(50 * time.Millisecond)
+	close(staleRelease)
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("request on removed node failed: %v", err)
+	}
+	if string(resp.Body()) != want {
+		t.Fatalf("expected response from %s, got %q", keep, resp.Body())
+	}
+}
@@ -1,17 +1,49 @@
 package httplb
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/valyala/fasthttp"
 )
 
+// ErrLBClosed 负载均衡器已关闭后，继续调用Do等请求函数时返回该错误
+var ErrLBClosed = errors.New("httplb: load balancer is closed")
+
+// ErrNoNodes 服务发现未能在Discovery.FetchTimeout内返回节点且配置中没有节点列表时，Do等请求函数返回该错误，
+// 此时Get()返回nil。之后服务发现推送节点列表时自动恢复
+var ErrNoNodes = errors.New("httplb: no nodes available")
+
 // LoadBalancer 负载均衡接口，提供Get()函数以获取分配的Client
 type LoadBalancer interface {
 	DoDeadline(req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error
 	DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error
 	Do(req *fasthttp.Request, resp *fasthttp.Response) error
+	// DoContext 选择节点并发送请求，节点选择、等待空闲连接及请求过程都受ctx的截止时间及取消控制
+	DoContext(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error
 	Get() Client
+
+	// Stats 获取各节点的负载统计
+	Stats() []NodeStats
+
+	// UpdateConfig 校验并应用新的配置，原子替换服务发现来源、负载均衡策略及Opts，
+	// 进行中的请求不受影响
+	UpdateConfig(config *Config) error
+
+	// Close 停止节点监听协程，取消正在进行的DNS/Consul查询，
+	// 等待进行中的请求完成后关闭所有HTTP Client
+	//
+	// Close之后再调用Do等函数将返回ErrLBClosed
+	Close() error
+}
+
+// KeyedLoadBalancer 支持按key选择节点的负载均衡器，New()返回的负载均衡器均实现该接口
+//
+// 策略不支持按key选择节点时，GetByKey与Get()一致
+type KeyedLoadBalancer interface {
+	LoadBalancer
+	GetByKey(key []byte) Client
 }
 
 // Client HTTP客户端接口，在原基础上添加Name()和Node()函数以方便获取节点信息
@@ -19,6 +51,9 @@ type Client interface {
 	fasthttp.BalancingClient
 	DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error
 	Do(req *fasthttp.Request, resp *fasthttp.Response) error
+	DoContext(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error
 	Name() string // 获取一个Node名称
 	Node() *Node  // 获取对应Node信息，包含IP/端口/权重等
+	// Healthy 获取节点是否通过所属负载均衡器的主动健康检查，未配置主动健康检查时始终为true
+	Healthy() bool
 }
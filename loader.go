@@ -0,0 +1,192 @@
+package httplb
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-playground/validator/v10"
+	"github.com/mitchellh/mapstructure"
+)
+
+// LoadFile 从TOML配置文件加载多个服务的负载均衡器，详见LoadTOML
+func LoadFile(path string) (map[string]LoadBalancer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadTOML(f)
+}
+
+// LoadTOML 解析TOML配置，校验后为每个服务创建负载均衡器，返回以服务名称为key的负载均衡器
+//
+// 每个服务配置在[services.<name>]中，字段与Config的toml标签一致，时间类型支持"1s"/"500ms"格式，如：
+//
+//	[services.user]
+//	type = "static"
+//	lb_strategy = "p2c"
+//	ip_list = ["10.85.101.122:8080 weight=100"]
+//
+//	[services.user.opts]
+//	max_conns = 10
+//	read_timeout = "1s"
+//
+// 任一服务配置有误时返回*ConfigError，包含服务名称及字段路径，此时不会创建任何负载均衡器。
+// 需要为服务设置Config.HealthCheckFunc等回调时，使用Manager.Configure及Manager.LoadTOML
+func LoadTOML(r io.Reader) (map[string]LoadBalancer, error) {
+	names, configs, err := loadConfigs(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// 各服务共享Consul/DNS客户端
+	res := newResources()
+	lbs := make(map[string]LoadBalancer, len(configs))
+	for _, name := range names {
+		configs[name].resources = res
+		lbs[name] = New(configs[name])
+	}
+	return lbs, nil
+}
+
+// 解析并校验TOML配置，返回按名称排序的服务列表及其配置
+func loadConfigs(r io.Reader) ([]string, map[string]*Config, error) {
+	configs, err := decodeTOML(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err = validateConfig(name, configs[name]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return names, configs, nil
+}
+
+// 校验服务配置，出错时返回*ConfigError
+func validateConfig(name string, config *Config) error {
+	if err := config.Validate(); err != nil {
+		return &ConfigError{Service: name, Field: validateErrorField(err), Err: err}
+	}
+	return nil
+}
+
+// ConfigError 服务配置错误，记录服务名称及出错的字段路径（如opts.read_timeout）
+type ConfigError struct {
+	Service string
+	Field   string
+	Err     error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("httplb: service %q: %v", e.Service, e.Err)
+	}
+	return fmt.Sprintf("httplb: service %q: field %q: %v", e.Service, e.Field, e.Err)
+}
+
+// 解析TOML中的[services.<name>]配置
+func decodeTOML(r io.Reader) (map[string]*Config, error) {
+	var raw struct {
+		Services map[string]map[string]interface{} `toml:"services"`
+	}
+	if _, err := toml.DecodeReader(r, &raw); err != nil {
+		return nil, err
+	}
+	if len(raw.Services) == 0 {
+		return nil, errors.New("httplb: no [services.<name>] found in config")
+	}
+
+	configs := make(map[string]*Config, len(raw.Services))
+	for name, service := range raw.Services {
+		config := &Config{}
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				textUnmarshalerHook,
+			),
+			ErrorUnused: true,
+			Result:      config,
+			TagName:     "toml",
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = decoder.Decode(service); err != nil {
+			return nil, &ConfigError{Service: name, Err: err}
+		}
+		configs[name] = config
+	}
+	return configs, nil
+}
+
+// textUnmarshalerHook 字符串解码到实现了encoding.TextUnmarshaler的类型（如LBStrategy）时调用其UnmarshalText
+func textUnmarshalerHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String {
+		return data, nil
+	}
+	v := reflect.New(to)
+	u, ok := v.Interface().(encoding.TextUnmarshaler)
+	if !ok {
+		return data, nil
+	}
+	if err := u.UnmarshalText([]byte(data.(string))); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// validateErrorField 将校验错误中的结构体字段路径转换为toml字段路径，如Config.Opts.MaxConns转换为opts.max_conns
+func validateErrorField(err error) string {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) || len(errs) == 0 {
+		return ""
+	}
+	segments := strings.Split(errs[0].StructNamespace(), ".")
+	t := reflect.TypeOf(Config{})
+	path := make([]string, 0, len(segments))
+	// DnsConfig/ConsulConfig等子配置单独校验时，命名空间以其类型名开头
+	if root := segments[0]; root != t.Name() {
+		for i := 0; i < t.NumField(); i++ {
+			if ft := t.Field(i).Type; ft.Kind() == reflect.Ptr && ft.Elem().Name() == root {
+				segments[0] = t.Field(i).Name
+				segments = append([]string{t.Name()}, segments...)
+				break
+			}
+		}
+	}
+	segments = segments[1:]
+	for _, seg := range segments {
+		name, index := seg, ""
+		if i := strings.IndexByte(seg, '['); i >= 0 {
+			name, index = seg[:i], seg[i:]
+		}
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		field, ok := t.FieldByName(name)
+		if !ok {
+			path = append(path, seg)
+			continue
+		}
+		if tag := strings.Split(field.Tag.Get("toml"), ",")[0]; tag != "" {
+			name = tag
+		}
+		path = append(path, name+index)
+		t = field.Type
+	}
+	return strings.Join(path, ".")
+}
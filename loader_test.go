@@ -0,0 +1,116 @@
+package httplb_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestLoadTOML(t *testing.T) {
+	addr, stop := startServer(t, okHandler)
+	defer stop()
+
+	conf := fmt.Sprintf(`
+[services.user]
+type = "static"
+lb_strategy = 1
+ip_list = ["%s weight=100"]
+
+[services.user.opts]
+max_conns = 10
+read_timeout = "1s"
+connect_timeout = "500ms"
+
+[services.order]
+type = "static"
+
+[[services.order.node_list]]
+ip = "%s"
+port = %s
+`, addr, strings.Split(addr, ":")[0], strings.Split(addr, ":")[1])
+
+	lbs, err := httplb.LoadTOML(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, lb := range lbs {
+			_ = lb.Close()
+		}
+	}()
+	if len(lbs) != 2 {
+		t.Fatalf("expected 2 services, got %d", len(lbs))
+	}
+	for name, lb := range lbs {
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		req.SetRequestURI("http://test/api")
+		if err = lb.Do(req, resp); err != nil {
+			t.Fatalf("service %s: %v", name, err)
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}
+}
+
+func TestLoadTOMLError(t *testing.T) {
+	cases := []struct {
+		conf    string
+		service string
+		field   string
+	}{
+		{
+			conf: `
+[services.user]
+type = "static"
+ip_list = ["127.0.0.1:8080"]
+[services.user.opts]
+read_timeout = "abc"
+`,
+			service: "user",
+		},
+		{
+			conf: `
+[services.order]
+type = "static"
+[[services.order.node_list]]
+port = 8080
+`,
+			service: "order",
+			field:   "node_list[0].ip",
+		},
+		{
+			conf: `
+[services.search]
+type = "static"
+ip_list = ["127.0.0.1:8080"]
+unknown_field = 1
+`,
+			service: "search",
+		},
+		{
+			conf: `
+[services.search]
+type = "dns"
+[services.search.dns]
+port = 80
+`,
+			service: "search",
+			field:   "dns.domain",
+		},
+	}
+	for _, c := range cases {
+		_, err := httplb.LoadTOML(strings.NewReader(c.conf))
+		cerr, ok := err.(*httplb.ConfigError)
+		if !ok {
+			t.Fatalf("expected *ConfigError, got %v", err)
+		}
+		if cerr.Service != c.service || cerr.Field != c.field {
+			t.Fatalf("unexpected error: %v", cerr)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package httplb
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// Logger 记录服务发现等后台协程中出现的错误，*log.Logger即实现了该接口
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// loggerHolder atomic.Value要求每次存储相同的具体类型，因此包装一层
+type loggerHolder struct {
+	l Logger
+}
+
+var logger atomic.Value // loggerHolder
+
+func init() {
+	SetLogger(log.New(os.Stderr, "httplb: ", log.LstdFlags))
+}
+
+// SetLogger 设置日志输出，默认输出到标准错误；l为nil时不输出日志
+func SetLogger(l Logger) {
+	logger.Store(loggerHolder{l})
+}
+
+func logf(format string, args ...interface{}) {
+	if l := logger.Load().(loggerHolder).l; l != nil {
+		l.Printf(format, args...)
+	}
+}
@@ -0,0 +1,92 @@
+package httplb
+
+import (
+	"sync/atomic"
+)
+
+// maglevTableSize Maglev查找表大小，需为质数且远大于节点数
+const maglevTableSize = 65537
+
+// maglevTable Maglev一致性哈希查找表
+//
+// 详见 https://research.google/pubs/pub44824/
+// 每个节点按偏移量offset和步长skip生成对查找表各槽位的偏好序列，各节点轮流占据其偏好序列中第一个空闲槽位，
+// 权重越大的节点每轮获得的次数越多。key按hash直接定位槽位，查找为O(1)。
+// 查找表构建后不再修改，通过atomic.Value整体替换，因此查找时无需加锁
+type maglevTable struct {
+	cs     []*lbClient
+	lookup []int32 // 槽位对应的节点下标
+	index  uint32  // 请求中没有key时轮询使用
+}
+
+func newMaglevTable(cs []*lbClient) *maglevTable {
+	const m = maglevTableSize
+	n := len(cs)
+	offsets := make([]uint64, n)
+	skips := make([]uint64, n)
+	credits := make([]float64, n)
+	weights := make([]float64, n)
+	var maxWeight float64
+	for i, c := range cs {
+		addr := c.Node().Addr()
+		offsets[i] = maglevHash(addr, "offset") % m
+		skips[i] = maglevHash(addr, "skip")%(m-1) + 1
+		weights[i] = float64(nodeWeight(c.Node()))
+		if weights[i] > maxWeight {
+			maxWeight = weights[i]
+		}
+	}
+
+	lookup := make([]int32, m)
+	for i := range lookup {
+		lookup[i] = -1
+	}
+	next := make([]uint64, n)
+	filled := 0
+	for filled < m {
+		for i := 0; i < n && filled < m; i++ {
+			// 权重最大的节点每轮占据一个槽位，其他节点按权重比例累计次数
+			credits[i] += weights[i] / maxWeight
+			for credits[i] >= 1 && filled < m {
+				credits[i]--
+				for {
+					slot := (offsets[i] + next[i]*skips[i]) % m
+					next[i]++
+					if lookup[slot] < 0 {
+						lookup[slot] = int32(i)
+						filled++
+						break
+					}
+				}
+			}
+		}
+	}
+	return &maglevTable{cs: cs, lookup: lookup}
+}
+
+// get 获取key对应的节点，key为空时轮询
+func (t *maglevTable) get(key []byte) *lbClient {
+	if len(key) == 0 {
+		i := atomic.AddUint32(&t.index, 1)
+		return t.cs[int(i%uint32(len(t.cs)))]
+	}
+	return t.cs[t.lookup[fnv64a(key)%maglevTableSize]]
+}
+
+func maglevHash(addr, seed string) uint64 {
+	return fnv64a([]byte(seed + addr))
+}
+
+// fnv64a FNV-1a哈希，避免hash/fnv在每次请求时分配对象
+func fnv64a(b []byte) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= prime64
+	}
+	return h
+}
@@ -0,0 +1,99 @@
+package httplb_test
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	httplb "http-loadbalance"
+)
+
+func TestMaglevWeights(t *testing.T) {
+	ipList := []string{
+		"10.0.0.1:8080 weight=100",
+		"10.0.0.2:8080 weight=100",
+		"10.0.0.3:8080 weight=200",
+	}
+	cfg := newStaticConfig(httplb.LBMaglev, ipList...)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg).(httplb.KeyedLoadBalancer)
+	defer lb.Close()
+
+	const keys = 40000
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[lb.GetByKey([]byte("key-"+strconv.Itoa(i))).Node().Addr()]++
+	}
+	expected := map[string]int{
+		"10.0.0.1:8080": keys / 4,
+		"10.0.0.2:8080": keys / 4,
+		"10.0.0.3:8080": keys / 2,
+	}
+	for addr, want := range expected {
+		if n := counts[addr]; n < want*9/10 || n > want*11/10 {
+			t.Fatalf("node %s got %d of %d keys, expected about %d", addr, n, keys, want)
+		}
+	}
+}
+
+func TestMaglevRemap(t *testing.T) {
+	var ipList []string
+	for i := 1; i <= 10; i++ {
+		ipList = append(ipList, fmt.Sprintf("10.0.0.%d:8080 weight=100", i))
+	}
+	cfg := newStaticConfig(httplb.LBMaglev, ipList...)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg).(httplb.KeyedLoadBalancer)
+	defer lb.Close()
+
+	const keys = 10000
+	before := make([]string, keys)
+	for i := 0; i < keys; i++ {
+		before[i] = lb.GetByKey([]byte("key-" + strconv.Itoa(i))).Node().Addr()
+	}
+
+	// 移除一个节点，其余节点上的key绝大部分保持不变
+	removed := "10.0.0.10:8080"
+	if err := lb.UpdateConfig(newStaticConfig(httplb.LBMaglev, ipList[:9]...)); err != nil {
+		t.Fatal(err)
+	}
+	disrupted := 0
+	for i := 0; i < keys; i++ {
+		addr := lb.GetByKey([]byte("key-" + strconv.Itoa(i))).Node().Addr()
+		if addr == removed {
+			t.Fatalf("key-%d still mapped to removed node", i)
+		}
+		if before[i] != removed && addr != before[i] {
+			disrupted++
+		}
+	}
+	if disrupted > keys/20 {
+		t.Fatalf("%d of %d keys on remaining nodes moved", disrupted, keys)
+	}
+}
+
+func BenchmarkMaglevGetByKey(b *testing.B) {
+	var ipList []string
+	for i := 1; i <= 100; i++ {
+		ipList = append(ipList, fmt.Sprintf("10.0.0.%d:8080", i))
+	}
+	cfg := newStaticConfig(httplb.LBMaglev, ipList...)
+	if err := cfg.Validate(); err != nil {
+		b.Fatal(err)
+	}
+	lb := httplb.New(cfg).(httplb.KeyedLoadBalancer)
+	defer lb.Close()
+
+	key := []byte("user-12345")
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			lb.GetByKey(key)
+		}
+	})
+}
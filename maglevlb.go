@@ -0,0 +1,68 @@
+package httplb
+
+import (
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// MaglevLB Maglev哈希负载均衡，按请求中的key将请求固定到同一节点
+//
+// 与ketama哈希环相比，各节点分配到的key更均匀，节点增减时key的迁移同样很少。
+// 节点变化时在watch协程中重建查找表，请求时通过atomic.Value读取查找表，无需加锁
+type MaglevLB struct {
+	*balancer
+
+	// KeyFunc 从请求中提取哈希key，设置后优先于Config.KeyFunc，默认按Config.HashKey提取
+	//
+	// 返回空key时请求以轮询方式分配
+	KeyFunc func(req *fasthttp.Request) []byte
+
+	table   atomic.Value // *maglevTable
+	keyFunc atomic.Value // func(req *fasthttp.Request) []byte
+}
+
+// NewMaglevLB 创建Maglev哈希负载均衡策略
+func NewMaglevLB(config *Config) *MaglevLB {
+	return newMaglevLB(config, nil)
+}
+
+// newMaglevLB 创建负载均衡器，core为切换负载均衡策略前的balancer，为nil时创建新的balancer
+func newMaglevLB(config *Config, core *balancer) *MaglevLB {
+	lb := &MaglevLB{}
+	lb.balancer = newBalancer(lb, config, core)
+	return lb
+}
+
+// GetByKey 获取key在查找表中对应的Client，key为空时以轮询方式获取，没有节点时返回nil
+func (cc *MaglevLB) GetByKey(key []byte) Client {
+	if len(cc.cs.load()) == 0 {
+		return nil
+	}
+	return cc.getByKey(key)
+}
+
+func (cc *MaglevLB) update(cs []*lbClient, config *Config) {
+	cc.table.Store(newMaglevTable(cs))
+	if config.KeyFunc != nil {
+		cc.keyFunc.Store(config.KeyFunc)
+	} else {
+		cc.keyFunc.Store(config.HashKey.keyFunc())
+	}
+}
+
+// choose 按请求中的key选取节点，Get()时以轮询方式选取
+func (cc *MaglevLB) choose(cs []*lbClient, req *fasthttp.Request) *lbClient {
+	if req == nil {
+		return cc.getByKey(nil)
+	}
+	keyFunc := cc.KeyFunc
+	if keyFunc == nil {
+		keyFunc = cc.keyFunc.Load().(func(req *fasthttp.Request) []byte)
+	}
+	return cc.getByKey(keyFunc(req))
+}
+
+func (cc *MaglevLB) getByKey(key []byte) *lbClient {
+	return cc.table.Load().(*maglevTable).get(key)
+}
@@ -0,0 +1,200 @@
+package httplb
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrServiceExists Manager.Add添加的服务已存在
+	ErrServiceExists = errors.New("httplb: service already exists")
+	// ErrServiceNotFound Manager中不存在该服务
+	ErrServiceNotFound = errors.New("httplb: service not found")
+)
+
+// Manager 管理多个命名服务的负载均衡器
+//
+// 所有服务共享Consul/DNS客户端，而不是每个watcher各自创建。
+// 可以在多个goroutine中并发调用Manager的方法
+type Manager struct {
+	// Configure 通过LoadTOML、LoadFile添加或更新服务前调用，可为服务设置配置文件中无法表示的回调，
+	// 如Config.HealthCheckFunc。应在第一次加载配置前设置
+	Configure func(name string, config *Config)
+
+	lock      sync.RWMutex
+	lbs       map[string]*dynamicLB
+	resources *resources
+}
+
+// ServiceSnapshot 服务的负载均衡器快照
+type ServiceSnapshot struct {
+	Name       string
+	Type       string // static/dns/consul或自定义的服务发现类型
+	LBStrategy LBStrategy
+	Nodes      []NodeStats
+}
+
+// NewManager 创建负载均衡器管理器
+func NewManager() *Manager {
+	return &Manager{
+		lbs:       make(map[string]*dynamicLB),
+		resources: newResources(),
+	}
+}
+
+// Get 获取服务的负载均衡器，服务不存在时返回nil
+func (m *Manager) Get(name string) LoadBalancer {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if lb, ok := m.lbs[name]; ok {
+		return lb
+	}
+	return nil
+}
+
+// Names 获取所有服务名称，按名称排序
+func (m *Manager) Names() []string {
+	m.lock.RLock()
+	names := make([]string, 0, len(m.lbs))
+	for name := range m.lbs {
+		names = append(names, name)
+	}
+	m.lock.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// Add 校验配置并添加服务，服务已存在时返回ErrServiceExists
+func (m *Manager) Add(name string, config *Config) error {
+	if m.Get(name) != nil {
+		return ErrServiceExists
+	}
+	if err := validateConfig(name, config); err != nil {
+		return err
+	}
+	config.resources = m.resources
+	lb := newDynamicLB(config)
+
+	m.lock.Lock()
+	if _, ok := m.lbs[name]; ok {
+		m.lock.Unlock()
+		_ = lb.Close()
+		return ErrServiceExists
+	}
+	m.lbs[name] = lb
+	m.lock.Unlock()
+	return nil
+}
+
+// Replace 更新服务的配置，服务不存在时添加
+//
+// 已存在的服务通过UpdateConfig热更新，进行中的请求不受影响
+func (m *Manager) Replace(name string, config *Config) error {
+	lb := m.Get(name)
+	if lb == nil {
+		if err := m.Add(name, config); err != ErrServiceExists {
+			return err
+		}
+		// 并发添加了同名服务，继续更新
+		lb = m.Get(name)
+	}
+	if err := validateConfig(name, config); err != nil {
+		return err
+	}
+	config.resources = m.resources
+	return lb.UpdateConfig(config)
+}
+
+// Remove 移除服务并关闭其负载均衡器，服务不存在时返回ErrServiceNotFound
+func (m *Manager) Remove(name string) error {
+	m.lock.Lock()
+	lb, ok := m.lbs[name]
+	delete(m.lbs, name)
+	m.lock.Unlock()
+	if !ok {
+		return ErrServiceNotFound
+	}
+	return lb.Close()
+}
+
+// CloseAll 移除并关闭所有服务的负载均衡器
+func (m *Manager) CloseAll() error {
+	m.lock.Lock()
+	lbs := m.lbs
+	m.lbs = make(map[string]*dynamicLB)
+	m.lock.Unlock()
+
+	var (
+		wg       sync.WaitGroup
+		errLock  sync.Mutex
+		firstErr error
+	)
+	for _, lb := range lbs {
+		wg.Add(1)
+		go func(lb *dynamicLB) {
+			defer wg.Done()
+			if err := lb.Close(); err != nil {
+				errLock.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				errLock.Unlock()
+			}
+		}(lb)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// Snapshot 获取所有服务的节点及负载统计，按服务名称排序
+func (m *Manager) Snapshot() []ServiceSnapshot {
+	m.lock.RLock()
+	snapshots := make([]ServiceSnapshot, 0, len(m.lbs))
+	for name, lb := range m.lbs {
+		config := lb.current().currentConfig()
+		snapshots = append(snapshots, ServiceSnapshot{
+			Name:       name,
+			Type:       config.Type,
+			LBStrategy: config.LBStrategy,
+			Nodes:      lb.Stats(),
+		})
+	}
+	m.lock.RUnlock()
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name < snapshots[j].Name
+	})
+	return snapshots
+}
+
+// LoadFile 从TOML配置文件加载服务，详见LoadTOML
+func (m *Manager) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.LoadTOML(f)
+}
+
+// LoadTOML 解析TOML配置中的[services.<name>]，添加新的服务并热更新已有的服务
+//
+// 配置中未出现的服务保持不变；任一服务配置有误时不做任何修改。
+// 设置了Configure时，每个服务的配置在添加或更新前由Configure补充回调
+func (m *Manager) LoadTOML(r io.Reader) error {
+	names, configs, err := loadConfigs(r)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if m.Configure != nil {
+			m.Configure(name, configs[name])
+		}
+		if err = m.Replace(name, configs[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
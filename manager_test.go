@@ -0,0 +1,75 @@
+package httplb_test
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestManager(t *testing.T) {
+	addrA, stopA := startServer(t, okHandler)
+	defer stopA()
+	addrB, stopB := startServer(t, okHandler)
+	defer stopB()
+
+	m := httplb.NewManager()
+	defer m.CloseAll()
+
+	if err := m.Add("user", newStaticConfig(httplb.LBRoundRobin, addrA)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add("user", newStaticConfig(httplb.LBRoundRobin, addrA)); err != httplb.ErrServiceExists {
+		t.Fatalf("expected ErrServiceExists, got %v", err)
+	}
+	if err := m.Replace("order", newStaticConfig(httplb.LBRandom, addrA, addrB)); err != nil {
+		t.Fatal(err)
+	}
+	if m.Get("unknown") != nil {
+		t.Fatal("expected nil for unknown service")
+	}
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/api")
+	for _, name := range m.Names() {
+		if err := m.Get(name).Do(req, resp); err != nil {
+			t.Fatalf("service %s: %v", name, err)
+		}
+	}
+
+	if err := m.Replace("user", newStaticConfig(httplb.LBLeastConnection, addrB)); err != nil {
+		t.Fatal(err)
+	}
+	snapshots := m.Snapshot()
+	if len(snapshots) != 2 || snapshots[0].Name != "order" || snapshots[1].Name != "user" {
+		t.Fatalf("unexpected snapshot: %+v", snapshots)
+	}
+	if len(snapshots[0].Nodes) != 2 {
+		t.Fatalf("expected 2 nodes for order, got %d", len(snapshots[0].Nodes))
+	}
+	user := snapshots[1]
+	if user.LBStrategy != httplb.LBLeastConnection || len(user.Nodes) != 1 || user.Nodes[0].Node.Addr() != addrB {
+		t.Fatalf("service user not replaced: %+v", user)
+	}
+
+	lb := m.Get("order")
+	if err := m.Remove("order"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove("order"); err != httplb.ErrServiceNotFound {
+		t.Fatalf("expected ErrServiceNotFound, got %v", err)
+	}
+	if err := lb.Do(req, resp); err != httplb.ErrLBClosed {
+		t.Fatalf("expected ErrLBClosed after Remove, got %v", err)
+	}
+	if err := m.CloseAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Names()) != 0 {
+		t.Fatal("expected no services after CloseAll")
+	}
+}
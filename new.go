@@ -1,10 +1,16 @@
 package httplb
 
 const (
-	LBRoundRobin         = iota + 1 // TODO 轮循
-	LBRandom                        // TODO 随机
-	LBWeightedRoundRobin            // 加权轮询
-	LBLeastConnection               // 最小连接数
+	LBRoundRobin              LBStrategy = iota + 1 // TODO 轮循
+	LBRandom                                        // TODO 随机
+	LBWeightedRoundRobin                            // 加权轮询
+	LBLeastConnection                               // 最小连接数
+	LBConsistentHash                                // 一致性哈希（ketama），按请求key固定节点
+	LBMaglev                                        // Maglev哈希，按请求key固定节点，分布更均匀
+	LBPowerOfTwo                                    // 随机二选一，随机选取两个节点中负载较低的节点
+	LBPeakEWMA                                      // 峰值EWMA耗时，随机二选一选取耗时×负载较低的节点
+	LBWeightedRandom                                // 加权随机
+	LBWeightedLeastConnection                       // 加权最小连接数，选择进行中的请求数/权重最小的节点
 
 	TypeDNS    = "dns"
 	TypeConsul = "consul"
@@ -12,20 +18,28 @@ const (
 
 	DNSTypeA   = "A"   // DNS A记录
 	DNSTypeSRV = "SRV" // DNS SRV记录
+
+	DNSFamilyV4         = "v4"          // 只使用IPv4地址（A记录）
+	DNSFamilyV6         = "v6"          // 只使用IPv6地址（AAAA记录）
+	DNSFamilyBoth       = "both"        // 同时使用IPv4及IPv6地址
+	DNSFamilyPreferIPv6 = "prefer-ipv6" // 域名有AAAA记录时只使用IPv6地址，没有时使用IPv4地址，不在连接时回退或竞速
+	// DNSFamilyHappyEyeballs 节点为IPv6地址，连接失败或happyEyeballsDelay内未建立时依次尝试同一域名的IPv4地址（RFC 8305），
+	// 域名没有AAAA记录时使用IPv4地址
+	DNSFamilyHappyEyeballs = "happy-eyeballs"
+
+	HashKeyHeader = "header" // 一致性哈希key取自请求头
+	HashKeyCookie = "cookie" // 一致性哈希key取自cookie
+	HashKeyQuery  = "query"  // 一致性哈希key取自query参数
+	HashKeyPath   = "path"   // 一致性哈希key取自URI路径
+
+	LoadMetricPending = "pending" // 节点负载为进行中的请求数
+	LoadMetricPenalty = "penalty" // 节点负载为进行中的请求数加上请求失败的惩罚值
+	LoadMetricLatency = "latency" // 节点负载为平均耗时与进行中的请求数的乘积
 )
 
 // New 创建HTTP负载均衡实例
+//
+// 返回的负载均衡器支持通过UpdateConfig切换LBStrategy
 func New(config *Config) LoadBalancer {
-	switch config.LBStrategy {
-	case LBRandom:
-		return NewRandomLB(config)
-	case LBRoundRobin:
-		return NewRoundRobinLB(config)
-	case LBLeastConnection:
-		return NewLeastLB(config)
-	case LBWeightedRoundRobin:
-		return NewWeightedRoundRobinLB(config)
-	default:
-		return NewLeastLB(config)
-	}
+	return newDynamicLB(config)
 }
@@ -2,8 +2,10 @@ package httplb
 
 import (
 	"fmt"
+	"net"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"http-loadbalance/libs/validate"
 )
@@ -13,40 +15,61 @@ type Node struct {
 	IP     string `toml:"ip" validate:"required"`        // IP地址
 	Port   uint16 `toml:"port" validate:"required"`      // 端口号
 	Weight uint16 `toml:"weight" validate:"default=100"` // 权重值
-	name   string // 一个节点的唯一标记
+	// Priority 优先级，值越小越优先（同SRV记录的Priority），只从优先级最高且有可用节点的一组中选取，默认0
+	Priority uint16   `toml:"priority"`
+	name     string   // 一个节点的唯一标记
+	fallback []string // happy-eyeballs时连接IPv6地址失败或超时后依次尝试的IPv4地址
 }
 
 func (i *Node) Validate() error {
 	return validate.Validator.Struct(i)
 }
 
-// Addr 获取节点服务地址，如：10.85.101.122:8080
+// Addr 获取节点服务地址，如：10.85.101.122:8080，IPv6地址为[::1]:8080
 func (i *Node) Addr() string {
-	return fmt.Sprintf("%s:%d", i.IP, i.Port)
+	return net.JoinHostPort(i.IP, strconv.Itoa(int(i.Port)))
 }
 
 func (i *Node) String() string {
 	if i.name == "" {
-		i.name = fmt.Sprintf("%s:%d_w%d", i.IP, i.Port, i.Weight)
+		i.name = fmt.Sprintf("%s_w%d", i.Addr(), i.Weight)
+		if i.Priority > 0 {
+			i.name += fmt.Sprintf("_p%d", i.Priority)
+		}
+		if len(i.fallback) > 0 {
+			// 回退地址变化时重新创建HTTP Client
+			i.name += "_f" + strings.Join(i.fallback, ",")
+		}
 	}
 	return i.name
 }
 
 var (
-	nodeInfoReg          = regexp.MustCompile(`^([\d.]+)(?:(?::(\d+))|)(?:(?:[\t ]+weight[\t ]*=[\t ]*(\d+))|)`)
+	nodeInfoReg          = regexp.MustCompile(`^(?:\[([\da-fA-F:.]+)\]|([\d.]+))(?:(?::(\d+))|)(?:(?:[\t ]+weight[\t ]*=[\t ]*(\d+))|)[\t ]*$`)
 	defaultPort   uint64 = 80
 	defaultWeight uint64 = 1
 )
 
-// newNode 新建IP Port节点，根据info字符串解析得出
+// newNode 新建IP Port节点，根据info字符串解析得出，IPv6地址需使用方括号，如：[::1]:8080
 func newNode(info string) (node *Node, err error) {
+	if host := strings.Fields(info); len(host) > 0 && !strings.HasPrefix(host[0], "[") && strings.Count(host[0], ":") > 1 {
+		// 未加方括号的IPv6地址无法区分地址与端口
+		return nil, fmt.Errorf("node info [%s] with IPv6 address without brackets. use \"[addr]:port\"", info)
+	}
 	g := nodeInfoReg.FindAllStringSubmatch(info, -1)
-	if len(g) < 1 || len(g[0]) < 3 {
+	if len(g) < 1 || len(g[0]) < 5 {
 		return nil, fmt.Errorf("node info [%s] with wrong format. check if \"IP[:Port][ weight=XXXX]\"", info)
 	}
-	ip := g[0][1]
-	port := g[0][2]
-	weight := g[0][3]
+	ip := g[0][2]
+	if g[0][1] != "" {
+		// 方括号中只能是IPv6地址
+		ip = g[0][1]
+		if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() != nil {
+			return nil, fmt.Errorf("node info [%s] with wrong IPv6 address", info)
+		}
+	}
+	port := g[0][3]
+	weight := g[0][4]
 
 	var portInt uint64
 	portInt, err = strconv.ParseUint(port, 10, 16)
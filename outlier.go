@@ -0,0 +1,342 @@
+package httplb
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	defaultOutlierInterval         = 10 * time.Second
+	defaultOutlierBaseEjectionTime = 30 * time.Second
+	defaultOutlierMaxEjectionTime  = 300 * time.Second
+)
+
+// outlierDetector 被动异常节点检测，参考Envoy的outlier detection
+//
+// 根据实际请求的结果，连续返回5xx或网关错误（502/503/504及连接错误）的节点，
+// 以及成功率明显低于其他节点的节点被暂时移出可选节点，移出时间随移出次数指数增长。
+// 被移出的节点数不超过MaxEjectionPercent，避免移出所有节点
+type outlierDetector struct {
+	target healthCheckTarget
+	done   <-chan struct{}
+	kick   chan struct{} // 请求中移出节点后通知重新选取可用节点
+
+	config atomic.Value // outlierConfigHolder
+
+	lock   sync.Mutex
+	states map[Client]*outlierState
+}
+
+// outlierConfigHolder atomic.Value不能存储nil，因此包装一层
+type outlierConfigHolder struct {
+	config *OutlierDetectionConfig
+}
+
+// outlierState 节点的请求结果统计及移出状态
+type outlierState struct {
+	detector *outlierDetector
+	client   Client
+	ejected  int32 // 非0表示被暂时移出
+
+	consecutive5xx     int32
+	consecutiveGateway int32
+	successes          uint64 // 本次统计周期内成功的请求数
+	total              uint64 // 本次统计周期内的请求数
+
+	// 以下字段由detector.lock保护
+	ejections    int       // 移出次数，决定下次移出的时间，未被移出的统计周期递减
+	ejectedUntil time.Time // 移出的截止时间
+	ejectedNow   bool      // 本次统计周期内被移出
+}
+
+func newOutlierDetector(target healthCheckTarget, done <-chan struct{}) *outlierDetector {
+	d := &outlierDetector{
+		target: target,
+		done:   done,
+		kick:   make(chan struct{}, 1),
+		states: make(map[Client]*outlierState),
+	}
+	d.config.Store(outlierConfigHolder{})
+	return d
+}
+
+// update 负载均衡器初始化或更新时设置检测参数，并移除已不存在的节点的统计。config为nil时不检测
+func (d *outlierDetector) update(config *OutlierDetectionConfig, clients []Client) {
+	d.config.Store(outlierConfigHolder{config})
+
+	d.lock.Lock()
+	states := make(map[Client]*outlierState, len(clients))
+	for _, c := range clients {
+		if s := d.states[c]; s != nil {
+			states[c] = s
+		}
+	}
+	d.states = states
+	d.lock.Unlock()
+}
+
+func (d *outlierDetector) currentConfig() *OutlierDetectionConfig {
+	return d.config.Load().(outlierConfigHolder).config
+}
+
+// state 获取Client对应的统计，不存在时创建
+func (d *outlierDetector) state(c Client) *outlierState {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	s := d.states[c]
+	if s == nil {
+		s = &outlierState{detector: d, client: c}
+		d.states[c] = s
+	}
+	return s
+}
+
+func (d *outlierDetector) run() {
+	timer := time.NewTimer(d.interval())
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-d.kick:
+			d.target.refresh()
+		case <-timer.C:
+			if d.evaluate() {
+				d.target.refresh()
+			}
+			timer.Reset(d.interval())
+		}
+	}
+}
+
+func (d *outlierDetector) interval() time.Duration {
+	if config := d.currentConfig(); config != nil {
+		return config.Interval
+	}
+	return defaultOutlierInterval
+}
+
+// evaluate 每个统计周期恢复到期的节点，并按成功率移出异常节点，返回是否有节点的移出状态发生变化
+func (d *outlierDetector) evaluate() bool {
+	config := d.currentConfig()
+	now := time.Now()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	changed := false
+	for _, s := range d.states {
+		if s.isEjected() && (config == nil || !now.Before(s.ejectedUntil)) {
+			s.setEjected(false)
+			changed = true
+		}
+	}
+	if config == nil {
+		return changed
+	}
+
+	if d.ejectBySuccessRate(config, now) {
+		changed = true
+	}
+	for _, s := range d.states {
+		if !s.ejectedNow && !s.isEjected() && s.ejections > 0 {
+			s.ejections--
+		}
+		s.ejectedNow = false
+		atomic.StoreUint64(&s.successes, 0)
+		atomic.StoreUint64(&s.total, 0)
+	}
+	return changed
+}
+
+// ejectBySuccessRate 移出成功率低于平均值-SuccessRateStdevFactor×标准差的节点
+//
+// 仅统计周期内请求数不少于SuccessRateRequestVolume的节点参与计算，且参与的节点数需不少于SuccessRateMinimumHosts
+func (d *outlierDetector) ejectBySuccessRate(config *OutlierDetectionConfig, now time.Time) bool {
+	type sample struct {
+		state *outlierState
+		rate  float64
+	}
+	samples := make([]sample, 0, len(d.states))
+	var sum float64
+	for _, s := range d.states {
+		total := atomic.LoadUint64(&s.total)
+		if s.isEjected() || total < uint64(config.SuccessRateRequestVolume) {
+			continue
+		}
+		rate := float64(atomic.LoadUint64(&s.successes)) * 100 / float64(total)
+		samples = append(samples, sample{s, rate})
+		sum += rate
+	}
+	if len(samples) == 0 || len(samples) < config.SuccessRateMinimumHosts {
+		return false
+	}
+
+	mean := sum / float64(len(samples))
+	var variance float64
+	for _, s := range samples {
+		variance += (s.rate - mean) * (s.rate - mean)
+	}
+	stdev := math.Sqrt(variance / float64(len(samples)))
+	threshold := mean - config.SuccessRateStdevFactor*stdev
+
+	changed := false
+	for _, s := range samples {
+		if s.rate < threshold && d.ejectLocked(s.state, config, now) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// eject 在请求中移出连续失败的节点，并通知重新选取可用节点
+func (d *outlierDetector) eject(s *outlierState, config *OutlierDetectionConfig) {
+	d.lock.Lock()
+	ejected := d.ejectLocked(s, config, time.Now())
+	d.lock.Unlock()
+	if ejected {
+		select {
+		case d.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ejectLocked 移出节点，移出时间为BaseEjectionTime×2^(移出次数-1)，不超过MaxEjectionTime。
+// 已移出的节点数达到MaxEjectionPercent时不再移出
+func (d *outlierDetector) ejectLocked(s *outlierState, config *OutlierDetectionConfig, now time.Time) bool {
+	if s.isEjected() || d.states[s.client] != s {
+		// 已移出或已从节点列表中移除
+		return false
+	}
+	ejected := 0
+	for _, other := range d.states {
+		if other.isEjected() {
+			ejected++
+		}
+	}
+	if ejected*100 >= config.MaxEjectionPercent*len(d.states) {
+		return false
+	}
+
+	s.ejections++
+	duration := config.MaxEjectionTime
+	if shift := uint(s.ejections - 1); shift < 32 {
+		if t := config.BaseEjectionTime << shift; t > 0 && t < duration {
+			duration = t
+		}
+	}
+	s.ejectedUntil = now.Add(duration)
+	s.ejectedNow = true
+	s.setEjected(true)
+	return true
+}
+
+// isEjected 获取节点是否因连续失败或成功率过低被暂时移出
+func (s *outlierState) isEjected() bool {
+	return atomic.LoadInt32(&s.ejected) != 0
+}
+
+func (s *outlierState) setEjected(ejected bool) {
+	var v int32
+	if ejected {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ejected, v)
+}
+
+// record 记录一次请求的结果，连续失败次数达到阈值时移出节点
+func (s *outlierState) record(resp *fasthttp.Response, err error) {
+	config := s.detector.currentConfig()
+	if config == nil {
+		return
+	}
+	if isLocalError(err) {
+		// 非节点原因导致的失败
+		return
+	}
+
+	status := fasthttp.StatusServiceUnavailable
+	if err == nil {
+		status = resp.StatusCode()
+	}
+	atomic.AddUint64(&s.total, 1)
+	if status < 500 {
+		atomic.AddUint64(&s.successes, 1)
+		atomic.StoreInt32(&s.consecutive5xx, 0)
+		atomic.StoreInt32(&s.consecutiveGateway, 0)
+		return
+	}
+
+	n5xx := atomic.AddInt32(&s.consecutive5xx, 1)
+	var nGateway int32
+	switch status {
+	case fasthttp.StatusBadGateway, fasthttp.StatusServiceUnavailable, fasthttp.StatusGatewayTimeout:
+		nGateway = atomic.AddInt32(&s.consecutiveGateway, 1)
+	default:
+		atomic.StoreInt32(&s.consecutiveGateway, 0)
+	}
+	if int(n5xx) >= config.Consecutive5xx || int(nGateway) >= config.ConsecutiveGatewayFailure {
+		atomic.StoreInt32(&s.consecutive5xx, 0)
+		atomic.StoreInt32(&s.consecutiveGateway, 0)
+		s.detector.eject(s, config)
+	}
+}
+
+// isLocalError 判断请求是否因本地原因失败，如调用方取消、连接池已满或负载均衡器已关闭，
+// 这些请求未到达节点，不计入异常检测。到达截止时间（context.DeadlineExceeded）视为节点超时，计入异常检测
+func isLocalError(err error) bool {
+	switch err {
+	case context.Canceled, fasthttp.ErrNoFreeConns, ErrLBClosed, ErrCircuitOpen:
+		return true
+	default:
+		return false
+	}
+}
+
+// availableClients 获取可选的节点，排除未通过主动健康检查、被移出及断路器打开的节点，
+// 并只保留其中Priority最小的一组，该组节点全部不可用时才使用下一优先级的节点（RFC 2782）。
+// 所有节点都不可用时返回Priority最小的一组，避免没有节点可用
+func availableClients(records []*lbClient) []*lbClient {
+	available := make([]*lbClient, 0, len(records))
+	for _, c := range records {
+		if c.available() {
+			available = append(available, c)
+		}
+	}
+	if len(available) == 0 {
+		return topPriority(records)
+	}
+	return topPriority(available)
+}
+
+// topPriority 获取Priority最小的一组节点，所有节点优先级相同时直接返回cs
+func topPriority(cs []*lbClient) []*lbClient {
+	if len(cs) == 0 {
+		return cs
+	}
+	min, mixed := cs[0].Node().Priority, false
+	for _, c := range cs[1:] {
+		if p := c.Node().Priority; p != min {
+			mixed = true
+			if p < min {
+				min = p
+			}
+		}
+	}
+	if !mixed {
+		return cs
+	}
+	group := make([]*lbClient, 0, len(cs))
+	for _, c := range cs {
+		if c.Node().Priority == min {
+			group = append(group, c)
+		}
+	}
+	return group
+}
@@ -0,0 +1,125 @@
+package httplb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestOutlierDetection(t *testing.T) {
+	failHandler := func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	}
+	addrA, stopA := startServer(t, failHandler)
+	defer stopA()
+	addrB, stopB := startServer(t, failHandler)
+	defer stopB()
+	addrC, stopC := startServer(t, okHandler)
+	defer stopC()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addrA, addrB, addrC)
+	cfg.OutlierDetection = &httplb.OutlierDetectionConfig{
+		Consecutive5xx:     3,
+		Interval:           20 * time.Millisecond,
+		BaseEjectionTime:   200 * time.Millisecond,
+		MaxEjectionPercent: 30,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + addrC + "/")
+
+	ejected := func() []string {
+		var addrs []string
+		for _, s := range lb.Stats() {
+			if s.Ejected {
+				addrs = append(addrs, s.Node.Addr())
+			}
+		}
+		return addrs
+	}
+	for i := 0; i < 30 && len(ejected()) == 0; i++ {
+		_ = lb.Do(req, resp)
+	}
+	first := ejected()
+	if len(first) != 1 || first[0] == addrC {
+		t.Fatalf("expected one failing node to be ejected, got %v", first)
+	}
+
+	// 达到MaxEjectionPercent后，另一个失败的节点不再被移出
+	for i := 0; i < 30; i++ {
+		_ = lb.Do(req, resp)
+	}
+	if got := ejected(); len(got) != 1 {
+		t.Fatalf("expected max ejection percent to cap ejections at 1, got %v", got)
+	}
+
+	// 移出时间到期后节点恢复
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ejected()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("ejected node was not re-admitted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 连接池已满等本地错误未到达节点，不计为网关错误
+func TestOutlierIgnoresLocalErrors(t *testing.T) {
+	addr, stop := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(200 * time.Millisecond)
+		okHandler(ctx)
+	})
+	defer stop()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addr)
+	cfg.Opts.MaxConns = 1
+	cfg.OutlierDetection = &httplb.OutlierDetectionConfig{
+		ConsecutiveGatewayFailure: 2,
+		MaxEjectionPercent:        100,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(resp)
+		req.SetRequestURI("http://test/")
+		done <- lb.Do(req, resp)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/")
+	for i := 0; i < 5; i++ {
+		if err := lb.Do(req, resp); err != fasthttp.ErrNoFreeConns {
+			t.Fatalf("expected ErrNoFreeConns while the pool is busy, got %v", err)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if s := lb.Stats(); s[0].Ejected {
+		t.Fatal("busy node ejected by local pool errors")
+	}
+}
@@ -0,0 +1,86 @@
+package httplb
+
+import (
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// PowerOfTwoLB 随机二选一（power of two choices）
+//
+// 每次请求随机选取两个节点，将请求分配给负载较低的节点，选择的时间复杂度为O(1)。
+// 与每次选择负载最低节点的LeastLoadedLB相比，多个客户端不会同时将请求集中到同一个看起来最空闲的节点
+type PowerOfTwoLB struct {
+	*balancer
+
+	// LoadFunc 计算节点的负载，值越小越优先，设置后优先于Config.LoadFunc及Config.LoadMetric
+	LoadFunc func(c Client) float64
+
+	load atomic.Value // func(c *lbClient) float64
+}
+
+// NewPowerOfTwoLB 创建随机二选一负载均衡策略
+func NewPowerOfTwoLB(config *Config) *PowerOfTwoLB {
+	return newPowerOfTwoLB(config, nil)
+}
+
+// newPowerOfTwoLB 创建负载均衡器，core为切换负载均衡策略前的balancer，为nil时创建新的balancer
+func newPowerOfTwoLB(config *Config, core *balancer) *PowerOfTwoLB {
+	lb := &PowerOfTwoLB{}
+	lb.balancer = newBalancer(lb, config, core)
+	return lb
+}
+
+func (cc *PowerOfTwoLB) update(cs []*lbClient, config *Config) {
+	if f := config.LoadFunc; f != nil {
+		cc.load.Store(func(c *lbClient) float64 { return f(c) })
+		return
+	}
+	cc.load.Store(loadMetricFunc(config.LoadMetric))
+}
+
+func (cc *PowerOfTwoLB) choose(cs []*lbClient, req *fasthttp.Request) *lbClient {
+	return p2cPick(cs, cc.loadOf)
+}
+
+func (cc *PowerOfTwoLB) loadOf(c *lbClient) float64 {
+	if cc.LoadFunc != nil {
+		return cc.LoadFunc(c)
+	}
+	return cc.load.Load().(func(c *lbClient) float64)(c)
+}
+
+// p2cPick 随机选取两个不同的节点，返回其中负载较低的节点
+func p2cPick(cs []*lbClient, load func(c *lbClient) float64) *lbClient {
+	if len(cs) == 1 {
+		return cs[0]
+	}
+	i := fastrandn(len(cs))
+	j := fastrandn(len(cs) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := cs[i], cs[j]
+	if load(b) < load(a) {
+		return b
+	}
+	return a
+}
+
+// loadMetricFunc 根据Config.LoadMetric获取节点负载的计算函数
+func loadMetricFunc(metric string) func(c *lbClient) float64 {
+	switch metric {
+	case LoadMetricPending:
+		return func(c *lbClient) float64 {
+			return float64(c.c.PendingRequests())
+		}
+	case LoadMetricLatency:
+		return func(c *lbClient) float64 {
+			return float64(c.Latency()) * float64(c.PendingRequests()+1)
+		}
+	default:
+		return func(c *lbClient) float64 {
+			return float64(c.PendingRequests())
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package httplb_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestPowerOfTwoLoadFunc(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBPowerOfTwo, "10.0.0.1:8080", "10.0.0.2:8080", "10.0.0.3:8080")
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LoadMetric != httplb.LoadMetricPenalty {
+		t.Fatalf("expected default load metric %q, got %q", httplb.LoadMetricPenalty, cfg.LoadMetric)
+	}
+	lb := httplb.NewPowerOfTwoLB(cfg)
+	defer lb.Close()
+	busy := "10.0.0.1:8080"
+	lb.LoadFunc = func(c httplb.Client) float64 {
+		if c.Node().Addr() == busy {
+			return 100
+		}
+		return 0
+	}
+
+	// 两次抽样中总有一个不是busy节点，busy节点不会被选中
+	for i := 0; i < 1000; i++ {
+		if addr := lb.Get().Node().Addr(); addr == busy {
+			t.Fatalf("request %d routed to busy node %s", i, addr)
+		}
+	}
+}
+
+// 通过New()创建时，Config中的LoadFunc及HealthCheckFunc同样生效
+func TestPowerOfTwoConfigFuncs(t *testing.T) {
+	addrA, stopA := startServer(t, okHandler)
+	defer stopA()
+	addrB, stopB := startServer(t, okHandler)
+	defer stopB()
+
+	var checked int32
+	cfg := newStaticConfig(httplb.LBPowerOfTwo, addrA, addrB)
+	cfg.LoadFunc = func(c httplb.Client) float64 {
+		if c.Node().Addr() == addrA {
+			return 100
+		}
+		return 0
+	}
+	cfg.HealthCheckFunc = func(req *fasthttp.Request, resp *fasthttp.Response, err error) bool {
+		atomic.AddInt32(&checked, 1)
+		return err == nil
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/")
+	for i := 0; i < 10; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&checked); n != 10 {
+		t.Fatalf("expected HealthCheckFunc to be called 10 times, got %d", n)
+	}
+	for _, s := range lb.Stats() {
+		if s.Node.Addr() == addrA && s.Total != 0 {
+			t.Fatalf("busy node %s handled %d requests", addrA, s.Total)
+		}
+	}
+}
+
+func TestLoadMetricValidate(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBPowerOfTwo, "10.0.0.1:8080")
+	cfg.LoadMetric = "cpu"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected unknown load metric to fail validation")
+	}
+}
+
+func BenchmarkPowerOfTwoVsLeastLoaded(b *testing.B) {
+	strategies := []struct {
+		name     string
+		strategy httplb.LBStrategy
+	}{
+		{"p2c", httplb.LBPowerOfTwo},
+		{"least", httplb.LBLeastConnection},
+	}
+	for _, n := range []int{10, 100, 1000} {
+		var ipList []string
+		for i := 0; i < n; i++ {
+			ipList = append(ipList, fmt.Sprintf("10.0.%d.%d:8080", i/250, i%250+1))
+		}
+		for _, s := range strategies {
+			b.Run(fmt.Sprintf("%s/nodes=%d", s.name, n), func(b *testing.B) {
+				cfg := newStaticConfig(s.strategy, ipList...)
+				if err := cfg.Validate(); err != nil {
+					b.Fatal(err)
+				}
+				lb := httplb.New(cfg)
+				defer lb.Close()
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						lb.Get()
+					}
+				})
+			})
+		}
+	}
+}
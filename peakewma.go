@@ -0,0 +1,100 @@
+package httplb
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultPeakEWMADecay Opts.PeakEWMADecay未配置时的衰减时间窗口
+	defaultPeakEWMADecay = 10 * time.Second
+
+	// peakEWMAPenalty 节点尚无耗时记录且有进行中的请求时的评分，使其在有耗时记录的节点之后被选择
+	peakEWMAPenalty = float64(math.MaxInt64 >> 16)
+)
+
+// peakEWMA 按时间衰减的峰值指数加权移动平均耗时
+//
+// 耗时高于当前值时立即取该耗时（峰值），否则按距上次更新的时间衰减后加权，
+// 衰减时间窗口越大，历史耗时的权重越大。读取时按当前时间衰减，长时间没有请求的节点耗时逐渐归零，
+// 因此恢复后的慢节点能重新获得请求。实现参考Finagle的PeakEwma
+//
+// 记录耗时时加锁串行化，读取时不加锁：写入前后各递增seq，读取到的seq为奇数或前后不一致时重新读取
+type peakEWMA struct {
+	lock  sync.Mutex // 串行化observe
+	seq   uint32     // 写入中为奇数
+	decay int64      // 衰减时间窗口，单位纳秒
+	cost  uint64     // 当前的平均耗时，单位纳秒，float64的位表示
+	stamp int64      // 上次更新的时间
+}
+
+func newPeakEWMA(decay time.Duration) *peakEWMA {
+	e := &peakEWMA{stamp: time.Now().UnixNano()}
+	e.setDecay(decay)
+	return e
+}
+
+// setDecay 设置衰减时间窗口，decay不大于0时使用defaultPeakEWMADecay
+func (e *peakEWMA) setDecay(decay time.Duration) {
+	if decay <= 0 {
+		decay = defaultPeakEWMADecay
+	}
+	atomic.StoreInt64(&e.decay, int64(decay))
+}
+
+// observe 记录一次请求耗时，返回更新后的平均耗时
+func (e *peakEWMA) observe(rtt time.Duration) float64 {
+	now := time.Now().UnixNano()
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	cost := e.decayed(math.Float64frombits(atomic.LoadUint64(&e.cost)), atomic.LoadInt64(&e.stamp), now, float64(rtt))
+	atomic.AddUint32(&e.seq, 1)
+	atomic.StoreInt64(&e.stamp, now)
+	atomic.StoreUint64(&e.cost, math.Float64bits(cost))
+	atomic.AddUint32(&e.seq, 1)
+	return cost
+}
+
+// get 获取按当前时间衰减后的平均耗时
+func (e *peakEWMA) get() float64 {
+	now := time.Now().UnixNano()
+	for {
+		seq := atomic.LoadUint32(&e.seq)
+		if seq&1 != 0 {
+			runtime.Gosched()
+			continue
+		}
+		cost := math.Float64frombits(atomic.LoadUint64(&e.cost))
+		stamp := atomic.LoadInt64(&e.stamp)
+		if atomic.LoadUint32(&e.seq) == seq {
+			return e.decayed(cost, stamp, now, 0)
+		}
+	}
+}
+
+// decayed 计算在now记录耗时rtt后的平均耗时，cost为stamp时的平均耗时
+func (e *peakEWMA) decayed(cost float64, stamp, now int64, rtt float64) float64 {
+	if rtt > cost {
+		return rtt
+	}
+	td := float64(now - stamp)
+	if td < 0 {
+		td = 0
+	}
+	w := math.Exp(-td / float64(atomic.LoadInt64(&e.decay)))
+	return cost*w + rtt*(1-w)
+}
+
+// peakEWMAScore 节点评分为耗时×(进行中的请求数+1)，评分越低越优先
+func peakEWMAScore(c *lbClient) float64 {
+	pending := c.PendingRequests()
+	cost := c.peakEWMA().get()
+	if cost == 0 && pending != 0 {
+		return peakEWMAPenalty + float64(pending)
+	}
+	return cost * float64(pending+1)
+}
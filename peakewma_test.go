@@ -0,0 +1,111 @@
+package httplb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestPeakEWMAPrefersFastNode(t *testing.T) {
+	fastAddr, stopFast := startServer(t, okHandler)
+	defer stopFast()
+	slowAddr, stopSlow := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(20 * time.Millisecond)
+		okHandler(ctx)
+	})
+	defer stopSlow()
+
+	cfg := newStaticConfig(httplb.LBPeakEWMA, fastAddr, slowAddr)
+	cfg.Opts.PeakEWMADecay = 5 * time.Second
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + fastAddr + "/")
+
+	const requests = 100
+	for i := 0; i < requests; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var fast, slow uint64
+	for _, s := range lb.Stats() {
+		switch s.Node.Addr() {
+		case fastAddr:
+			fast = s.Total
+		case slowAddr:
+			slow = s.Total
+		}
+	}
+	if fast+slow != requests || slow > requests/10 {
+		t.Fatalf("expected most requests on the fast node, got fast=%d slow=%d", fast, slow)
+	}
+}
+
+// 节点状态或配置变化重建可选节点后，各节点的耗时统计保留
+func TestPeakEWMAKeepsCostAcrossRefresh(t *testing.T) {
+	fastAddr, stopFast := startServer(t, okHandler)
+	defer stopFast()
+	slowAddr, stopSlow := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(20 * time.Millisecond)
+		okHandler(ctx)
+	})
+	defer stopSlow()
+
+	newConfig := func(requestTimeout time.Duration) *httplb.Config {
+		cfg := newStaticConfig(httplb.LBPeakEWMA, fastAddr, slowAddr)
+		cfg.Opts.PeakEWMADecay = 5 * time.Second
+		cfg.Opts.RequestTimeout = requestTimeout
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+	lb := httplb.New(newConfig(time.Second))
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + fastAddr + "/")
+
+	// 两个节点都有耗时记录后才开始比较
+	for i := 0; i < 20; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slowTotal := func() uint64 {
+		for _, s := range lb.Stats() {
+			if s.Node.Addr() == slowAddr {
+				return s.Total
+			}
+		}
+		return 0
+	}
+	before := slowTotal()
+	const rounds = 20
+	for i := 0; i < rounds; i++ {
+		// RequestTimeout不影响HTTP Client，更新后复用节点并重建可选节点
+		if err := lb.UpdateConfig(newConfig(time.Second + time.Duration(i+1)*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if slow := slowTotal() - before; slow > 2 {
+		t.Fatalf("slow node selected %d/%d times after refresh", slow, rounds)
+	}
+}
@@ -0,0 +1,40 @@
+package httplb
+
+import "github.com/valyala/fasthttp"
+
+// PeakEWMALB 基于峰值EWMA耗时的负载均衡
+//
+// 记录各节点按时间衰减的峰值平均耗时，节点评分为耗时×(进行中的请求数+1)，
+// 以随机二选一的方式选择评分较低的节点，慢节点获得的请求相应减少。
+// 衰减时间窗口通过Opts.PeakEWMADecay配置
+type PeakEWMALB struct {
+	*balancer
+}
+
+// NewPeakEWMALB 创建峰值EWMA耗时负载均衡策略
+func NewPeakEWMALB(config *Config) *PeakEWMALB {
+	return newPeakEWMALB(config, nil)
+}
+
+// newPeakEWMALB 创建负载均衡器，core为切换负载均衡策略前的balancer，为nil时创建新的balancer
+func newPeakEWMALB(config *Config, core *balancer) *PeakEWMALB {
+	lb := &PeakEWMALB{}
+	lb.balancer = newBalancer(lb, config, core)
+	return lb
+}
+
+// update 为尚无耗时统计的节点创建统计，统计保存在节点的记录上，节点列表、节点状态变化或切换负载均衡策略时沿用
+func (cc *PeakEWMALB) update(cs []*lbClient, config *Config) {
+	decay := config.Opts.PeakEWMADecay
+	for _, c := range cs {
+		if e := c.peakEWMA(); e != nil {
+			e.setDecay(decay)
+		} else {
+			c.peak.Store(newPeakEWMA(decay))
+		}
+	}
+}
+
+func (cc *PeakEWMALB) choose(cs []*lbClient, req *fasthttp.Request) *lbClient {
+	return p2cPick(cs, peakEWMAScore)
+}
@@ -0,0 +1,78 @@
+package httplb
+
+import (
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Picker 自定义负载均衡策略的选取算法，通过RegisterStrategy注册
+//
+// 服务发现、HTTP Client、健康检查、离群检测及熔断均由负载均衡器处理，
+// Picker只需从可用节点中选取一个节点
+type Picker interface {
+	// Pick 从当前可用节点的快照nodes中选取发送req的节点，必须返回nodes中的元素
+	//
+	// nodes不为空且只包含通过健康检查、未被异常检测移出且未熔断的节点（全部不可用时包含所有节点），
+	// 发布后不再修改，Pick不能修改nodes。通过Get()选取节点时req为nil。
+	// Pick会被并发调用
+	Pick(nodes []Client, req *fasthttp.Request) Client
+}
+
+// PickerUpdater 需要根据可用节点预先计算状态的Picker可实现该接口，如按机架对节点分组
+type PickerUpdater interface {
+	// Update 可用节点变化时调用，之后Pick收到的nodes即为此次的nodes。
+	// Update不会被并发调用，但可能与Pick并发执行
+	Update(nodes []Client)
+}
+
+// StrategyFactory 创建Picker，New()或UpdateConfig切换到该策略时调用
+type StrategyFactory func(config *Config) Picker
+
+// RegisterStrategy 注册自定义负载均衡策略，返回分配的策略ID
+//
+// 注册后可在TOML中通过名称选择该策略，如 lb_strategy = "rack"，
+// 或将Config.LBStrategy设置为返回的ID。名称在解析配置时查找，因此需在解析配置前注册。
+// 应在init()中注册，名称为空、factory为nil或名称重复时panic
+func RegisterStrategy(name string, factory StrategyFactory) LBStrategy {
+	if name == "" || factory == nil {
+		panic("httplb: RegisterStrategy requires a name and a factory")
+	}
+	return registerCustomStrategy(name, func(config *Config, core *balancer) reloadableLB {
+		return newPickerLB(config, core, factory(config))
+	})
+}
+
+// pickerLB 使用自定义Picker选取节点的负载均衡器
+type pickerLB struct {
+	*balancer
+
+	custom Picker
+	nodes  atomic.Value // []Client，传给custom的可用节点快照
+}
+
+func newPickerLB(config *Config, core *balancer, p Picker) *pickerLB {
+	lb := &pickerLB{custom: p}
+	lb.balancer = newBalancer(lb, config, core)
+	return lb
+}
+
+func (cc *pickerLB) update(cs []*lbClient, config *Config) {
+	nodes := make([]Client, len(cs))
+	for i, c := range cs {
+		nodes[i] = c
+	}
+	if u, ok := cc.custom.(PickerUpdater); ok {
+		u.Update(nodes)
+	}
+	cc.nodes.Store(nodes)
+}
+
+// choose 由自定义Picker选取节点，返回的不是快照中的节点时使用第一个节点
+func (cc *pickerLB) choose(cs []*lbClient, req *fasthttp.Request) *lbClient {
+	nodes := cc.nodes.Load().([]Client)
+	if c, ok := cc.custom.Pick(nodes, req).(*lbClient); ok && c != nil {
+		return c
+	}
+	return cs[0]
+}
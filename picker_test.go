@@ -0,0 +1,121 @@
+package httplb_test
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+// rackPicker 优先选取本机架（IP前缀相同）的节点
+type rackPicker struct {
+	prefix  string
+	local   atomic.Value // []httplb.Client
+	updates int32
+}
+
+func (p *rackPicker) Update(nodes []httplb.Client) {
+	var local []httplb.Client
+	for _, c := range nodes {
+		if strings.HasPrefix(c.Node().IP, p.prefix) {
+			local = append(local, c)
+		}
+	}
+	p.local.Store(local)
+	atomic.AddInt32(&p.updates, 1)
+}
+
+func (p *rackPicker) Pick(nodes []httplb.Client, req *fasthttp.Request) httplb.Client {
+	if local := p.local.Load().([]httplb.Client); len(local) > 0 {
+		return local[0]
+	}
+	return nodes[0]
+}
+
+var testRackPicker = &rackPicker{prefix: "10.0.2."}
+
+var testRackStrategy = httplb.RegisterStrategy("test_rack", func(config *httplb.Config) httplb.Picker {
+	return testRackPicker
+})
+
+func TestRegisterStrategy(t *testing.T) {
+	conf := `
+[services.user]
+type = "static"
+lb_strategy = "test_rack"
+ip_list = ["10.0.1.1:8080", "10.0.2.1:8080", "10.0.3.1:8080"]
+`
+	lbs, err := httplb.LoadTOML(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := lbs["user"]
+	defer lb.Close()
+
+	for i := 0; i < 10; i++ {
+		if got := lb.Get().Node().Addr(); got != "10.0.2.1:8080" {
+			t.Fatalf("expected node in local rack, got %s", got)
+		}
+	}
+	if atomic.LoadInt32(&testRackPicker.updates) == 0 {
+		t.Fatal("PickerUpdater.Update was not called")
+	}
+
+	cfg := newStaticConfig(testRackStrategy, "10.0.1.1:8080", "10.0.2.1:8080")
+	if err = cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb2 := httplb.New(cfg)
+	defer lb2.Close()
+	if got := lb2.Get().Node().Addr(); got != "10.0.2.1:8080" {
+		t.Fatalf("expected node in local rack, got %s", got)
+	}
+}
+
+func TestStrategyName(t *testing.T) {
+	conf := `
+[services.user]
+type = "static"
+lb_strategy = "p2c"
+ip_list = ["10.0.1.1:8080"]
+`
+	lbs, err := httplb.LoadTOML(strings.NewReader(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lb := range lbs {
+		lb.Close()
+	}
+
+	_, err = httplb.LoadTOML(strings.NewReader(strings.Replace(conf, `"p2c"`, `"no_such_strategy"`, 1)))
+	if err == nil || !strings.Contains(err.Error(), "no_such_strategy") {
+		t.Fatalf("expected unknown strategy error, got %v", err)
+	}
+
+	// 直接解析到Config时同样支持策略名称及ID
+	var decoded httplb.Config
+	if _, err = toml.Decode(`lb_strategy = "p2c"`, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.LBStrategy != httplb.LBPowerOfTwo {
+		t.Fatalf("expected p2c, got %v", decoded.LBStrategy)
+	}
+	if _, err = toml.Decode(`lb_strategy = 8`, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.LBStrategy != httplb.LBPeakEWMA {
+		t.Fatalf("expected peak_ewma, got %v", decoded.LBStrategy)
+	}
+	if _, err = toml.Decode(`lb_strategy = "no_such_strategy"`, &decoded); err == nil {
+		t.Fatal("expected unknown strategy error")
+	}
+
+	cfg := newStaticConfig(999, "10.0.1.1:8080")
+	if err = cfg.Validate(); err == nil {
+		t.Fatal("expected unknown LBStrategy to fail Validate")
+	}
+}
@@ -0,0 +1,218 @@
+package httplb
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// reloadableLB New()可创建的各负载均衡器实现，切换负载均衡策略时新策略的负载均衡器沿用其balancer
+type reloadableLB interface {
+	LoadBalancer
+	picker
+	currentConfig() *Config
+	// core 获取各策略负载均衡器共用的balancer
+	core() *balancer
+}
+
+// dynamicLB New()返回的负载均衡器
+//
+// 在各策略负载均衡器的基础上，支持UpdateConfig时切换LBStrategy：
+// 新策略的负载均衡器沿用当前的balancer，只替换其picker，服务发现来源、HTTP Clients、
+// 各节点的负载统计及健康、移出、熔断状态和重试预算均保持不变
+type dynamicLB struct {
+	lb         atomic.Value // lbHolder
+	reloadLock sync.Mutex   // 串行化UpdateConfig
+}
+
+// lbHolder atomic.Value要求每次存储相同的具体类型，因此包装一层
+type lbHolder struct {
+	lb reloadableLB
+}
+
+func newDynamicLB(config *Config) *dynamicLB {
+	d := &dynamicLB{}
+	d.lb.Store(lbHolder{newByStrategy(config, nil)})
+	return d
+}
+
+// newByStrategy 根据LBStrategy创建已注册策略的负载均衡器，core不为nil时沿用core而不创建新的balancer
+//
+// 未通过Validate校验的配置中LBStrategy未注册时使用最小连接数
+func newByStrategy(config *Config, core *balancer) reloadableLB {
+	s, ok := lookupStrategy(config.LBStrategy)
+	if !ok {
+		s, _ = lookupStrategy(LBLeastConnection)
+	}
+	return s.newLB(config, core)
+}
+
+func (d *dynamicLB) current() reloadableLB {
+	return d.lb.Load().(lbHolder).lb
+}
+
+func (d *dynamicLB) DoDeadline(req *fasthttp.Request, resp *fasthttp.Response, deadline time.Time) error {
+	return d.current().DoDeadline(req, resp, deadline)
+}
+
+func (d *dynamicLB) DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error {
+	return d.current().DoTimeout(req, resp, timeout)
+}
+
+// Do 按TimeoutHeader、Opts.RequestTimeout或DefaultLBClientTimeout计算截止时间并发送请求，
+// 超时时返回ErrSelectionTimeout或ErrUpstreamTimeout
+func (d *dynamicLB) Do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	return d.current().Do(req, resp)
+}
+
+// DoContext 在ctx控制下发送请求，ctx没有截止时间时使用与Do相同的超时时间，
+// 可通过WithRequestTimeout或TimeoutHeader指定本次请求的超时时间
+func (d *dynamicLB) DoContext(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response) error {
+	return d.current().DoContext(ctx, req, resp)
+}
+
+func (d *dynamicLB) Get() Client {
+	return d.current().Get()
+}
+
+// GetByKey 当前策略支持按key选择节点（如LBConsistentHash）时获取key对应的Client，否则同Get()
+func (d *dynamicLB) GetByKey(key []byte) Client {
+	lb := d.current()
+	if klb, ok := lb.(KeyedLoadBalancer); ok {
+		return klb.GetByKey(key)
+	}
+	return lb.Get()
+}
+
+func (d *dynamicLB) Stats() []NodeStats {
+	return d.current().Stats()
+}
+
+// UpdateConfig 校验并应用新的配置
+//
+// LBStrategy未变化时由当前负载均衡器更新服务发现来源及Opts；
+// 变化时创建沿用当前balancer的新策略负载均衡器，balancer在应用配置的同时替换picker，之后发布新的负载均衡器。
+// 进行中的请求不受影响，等待新的服务发现来源期间调用Close不会被阻塞，此时UpdateConfig返回ErrLBClosed
+func (d *dynamicLB) UpdateConfig(config *Config) error {
+	d.reloadLock.Lock()
+	defer d.reloadLock.Unlock()
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	old := d.current()
+	if old.currentConfig().LBStrategy == config.LBStrategy {
+		return old.UpdateConfig(config)
+	}
+
+	lb := newByStrategy(config, old.core())
+	if err := lb.core().updateConfig(config, lb); err != nil {
+		return err
+	}
+	d.lb.Store(lbHolder{lb})
+	return nil
+}
+
+func (d *dynamicLB) Close() error {
+	return d.current().Close()
+}
+
+// 判断服务发现来源是否一致，一致时可继续使用原有的watcher
+func sameDiscovery(a, b *Config) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if (a.Consul == nil) != (b.Consul == nil) || (a.Consul != nil && *a.Consul != *b.Consul) {
+		return false
+	}
+	if (a.DNS == nil) != (b.DNS == nil) || (a.DNS != nil && !reflect.DeepEqual(*a.DNS, *b.DNS)) {
+		return false
+	}
+	if len(a.NodeList) != len(b.NodeList) {
+		return false
+	}
+	for i := range a.NodeList {
+		if a.NodeList[i].String() != b.NodeList[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+// 判断两个Opts中创建HTTP Client所用的配置是否一致，仅由负载均衡器使用的配置不影响HTTP Client
+func optsEqual(a, b *Opts) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	x, y := *a, *b
+	x.PeakEWMADecay, y.PeakEWMADecay = 0, 0
+	x.RequestTimeout, y.RequestTimeout = 0, 0
+	return x == y
+}
+
+// 根据新的节点列表和Opts重建HTTP Clients，Opts未变化时复用已有的Client
+func reloadClients(oldClients []Client, nodes []*Node, oldOpts, newOpts *Opts) []Client {
+	if !optsEqual(oldOpts, newOpts) {
+		return createClients(nodes, newOpts)
+	}
+	newClients, _ := updateClients(oldClients, nodes, newOpts)
+	return newClients
+}
+
+// 获取HTTP Clients对应的节点列表
+func clientNodes(clients []Client) []*Node {
+	nodes := make([]*Node, 0, len(clients))
+	for _, c := range clients {
+		nodes = append(nodes, c.Node())
+	}
+	return nodes
+}
+
+// 等待新的服务发现来源推送第一次非空的节点列表，超过Discovery.FetchTimeout、来源已关闭或done关闭时关闭watcher并返回错误
+func fetchNodes(w *watcher, config *Config, done <-chan struct{}) ([]*Node, error) {
+	nodes, err := waitNodes(w, config, done)
+	if err != nil {
+		w.close()
+	}
+	return nodes, err
+}
+
+// waitNodes 等待w推送第一次非空的节点列表，超过discoveryFetchTimeout、w已关闭或done关闭时返回错误
+func waitNodes(w *watcher, config *Config, done <-chan struct{}) ([]*Node, error) {
+	timer := time.NewTimer(discoveryFetchTimeout(config))
+	defer timer.Stop()
+	for {
+		select {
+		case nodes, ok := <-w.Updates():
+			if !ok {
+				return nil, errDiscoveryClosed
+			}
+			if len(nodes) > 0 {
+				return nodes, nil
+			}
+		case <-timer.C:
+			return nil, errDiscoveryTimeout
+		case <-done:
+			return nil, ErrLBClosed
+		}
+	}
+}
+
+// discoveryFetchTimeout 获取等待第一次节点列表的最长时间
+func discoveryFetchTimeout(config *Config) time.Duration {
+	if config.Discovery != nil && config.Discovery.FetchTimeout > 0 {
+		return config.Discovery.FetchTimeout
+	}
+	return defaultDiscoveryFetchTimeout
+}
+
+const defaultDiscoveryFetchTimeout = 10 * time.Second
+
+var (
+	errDiscoveryClosed  = errors.New("httplb: service discovery stopped before returning any nodes")
+	errDiscoveryTimeout = errors.New("httplb: timed out waiting for service discovery")
+)
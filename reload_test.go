@@ -0,0 +1,249 @@
+package httplb_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestUpdateConfig(t *testing.T) {
+	nameHandler := func(name string) fasthttp.RequestHandler {
+		return func(ctx *fasthttp.RequestCtx) {
+			time.Sleep(time.Millisecond * 5)
+			ctx.SetBodyString(name)
+		}
+	}
+	addrA, stopA := startServer(t, nameHandler("a"))
+	defer stopA()
+	addrB, stopB := startServer(t, nameHandler("b"))
+	defer stopB()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addrA)
+	cfg.Opts.MaxConns = 16
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	// 更新配置期间持续发送请求，不应出现失败
+	var (
+		wg     sync.WaitGroup
+		stopCh = make(chan struct{})
+		errCh  = make(chan error, 1)
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := fasthttp.AcquireRequest()
+			defer fasthttp.ReleaseRequest(req)
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseResponse(resp)
+			req.SetRequestURI("http://test/api")
+			for {
+				select {
+				case <-stopCh:
+					return
+				default:
+				}
+				if err := lb.Do(req, resp); err != nil {
+					select {
+					case errCh <- err:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	newCfg := newStaticConfig(httplb.LBLeastConnection, addrB)
+	newCfg.Opts.MaxConns = 32
+	if err := lb.UpdateConfig(newCfg); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	newCfg = newStaticConfig(httplb.LBLeastConnection, addrA, addrB)
+	newCfg.Opts.MaxConns = 32
+	if err := lb.UpdateConfig(newCfg); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 50)
+	close(stopCh)
+	wg.Wait()
+	select {
+	case err := <-errCh:
+		t.Fatalf("request failed during reload: %v", err)
+	default:
+	}
+
+	if err := lb.UpdateConfig(newStaticConfig(httplb.LBRoundRobin, addrB)); err != nil {
+		t.Fatal(err)
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/api")
+	for i := 0; i < 4; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Body()) != "b" {
+			t.Fatalf("expected response from new node, got %q", resp.Body())
+		}
+	}
+
+	if err := lb.UpdateConfig(&httplb.Config{Type: httplb.TypeStatic}); err == nil {
+		t.Fatal("expected validation error for empty static config")
+	}
+}
+
+// 切换负载均衡策略时沿用原有的服务发现来源、HTTP Clients及各节点的状态
+func TestUpdateConfigStrategyKeepsState(t *testing.T) {
+	addrA, stopA := startServer(t, okHandler)
+	defer stopA()
+	addrB := closedAddr(t)
+
+	config := func(strategy httplb.LBStrategy) *httplb.Config {
+		cfg := newStaticConfig(strategy, addrA, addrB)
+		cfg.Type = "test_registry"
+		cfg.CircuitBreaker = &httplb.CircuitBreakerConfig{
+			ConsecutiveFailures: 1,
+			OpenTimeout:         time.Minute,
+		}
+		return cfg
+	}
+	cfg := config(httplb.LBRoundRobin)
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	r := <-testRegistries
+
+	stats := func() map[string]httplb.NodeStats {
+		m := make(map[string]httplb.NodeStats)
+		for _, s := range lb.Stats() {
+			m[s.Node.Addr()] = s
+		}
+		return m
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://test/")
+	for i := 0; i < 10 && stats()[addrB].Circuit != httplb.CircuitOpen; i++ {
+		_ = lb.Do(req, resp)
+	}
+	if err := lb.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	before := stats()
+	if before[addrB].Circuit != httplb.CircuitOpen || before[addrA].Total == 0 {
+		t.Fatalf("unexpected stats before switching strategy: %+v", before)
+	}
+
+	if err := lb.UpdateConfig(config(httplb.LBLeastConnection)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-testRegistries:
+		t.Fatal("switching strategy created a new discovery source")
+	default:
+	}
+	after := stats()
+	if after[addrB].Circuit != httplb.CircuitOpen {
+		t.Fatalf("circuit breaker state reset after switching strategy: %+v", after[addrB])
+	}
+	if after[addrA].Total != before[addrA].Total {
+		t.Fatalf("request stats reset after switching strategy: %d != %d", after[addrA].Total, before[addrA].Total)
+	}
+	if err := lb.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// 原有的服务发现来源继续推送节点列表
+	r.push(&httplb.Node{IP: "127.0.0.1", Port: 1, Weight: 100})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if addrs := statsAddrs(lb); len(addrs) == 1 && addrs[0] == "127.0.0.1:1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes pushed by the original discovery were not applied, got %v", statsAddrs(lb))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 新的服务发现来源一直无法获取节点时，UpdateConfig在FetchTimeout后返回错误，等待期间Close不被阻塞
+func TestUpdateConfigDiscoveryTimeout(t *testing.T) {
+	addr, stop := startServer(t, okHandler)
+	defer stop()
+
+	dnsConfig := func(strategy httplb.LBStrategy) *httplb.Config {
+		return &httplb.Config{
+			Type:       httplb.TypeDNS,
+			LBStrategy: strategy,
+			DNS: &httplb.DnsConfig{
+				Domain:     "svc.example.com",
+				ResolvFile: filepath.Join(os.TempDir(), "httplb-no-such-resolv.conf"),
+				DNSServer:  closedUDPAddr(t),
+			},
+			Discovery: &httplb.DiscoveryConfig{FetchTimeout: 200 * time.Millisecond},
+		}
+	}
+	for _, strategy := range []httplb.LBStrategy{httplb.LBRoundRobin, httplb.LBLeastConnection} {
+		cfg := newStaticConfig(httplb.LBRoundRobin, addr)
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		lb := httplb.New(cfg)
+
+		start := time.Now()
+		if err := lb.UpdateConfig(dnsConfig(strategy)); err == nil {
+			t.Fatal("expected error when discovery returns no nodes")
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Fatalf("UpdateConfig blocked for %s", elapsed)
+		}
+		if stats := lb.Stats(); len(stats) != 1 || stats[0].Node.Addr() != addr {
+			t.Fatalf("config changed after failed update: %v", stats)
+		}
+
+		// 等待服务发现期间Close立即返回，UpdateConfig返回ErrLBClosed
+		cfg = dnsConfig(strategy)
+		cfg.Discovery.FetchTimeout = time.Minute
+		errCh := make(chan error, 1)
+		go func() { errCh <- lb.UpdateConfig(cfg) }()
+		time.Sleep(50 * time.Millisecond)
+		closed := make(chan struct{})
+		go func() {
+			_ = lb.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Close blocked by pending UpdateConfig")
+		}
+		select {
+		case err := <-errCh:
+			if err != httplb.ErrLBClosed {
+				t.Fatalf("expected ErrLBClosed, got %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("UpdateConfig still blocked after Close")
+		}
+	}
+}
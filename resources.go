@@ -0,0 +1,43 @@
+package httplb
+
+import (
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/miekg/dns"
+)
+
+// resources 多个服务间共享的资源，避免每个watcher各自创建Consul/DNS客户端
+//
+// 由Manager及LoadTOML创建，通过Config传递给watcher
+type resources struct {
+	lock          sync.Mutex
+	consulClients map[string]*api.Client // 以consul地址为key
+	dnsClient     *dns.Client
+	dnsTCPClient  *dns.Client // UDP应答被截断时使用
+}
+
+func newResources() *resources {
+	return &resources{
+		consulClients: make(map[string]*api.Client),
+		dnsClient:     &dns.Client{},
+		dnsTCPClient:  &dns.Client{Net: "tcp"},
+	}
+}
+
+// consulClient 获取指定地址的consul客户端，同一地址只创建一次
+func (r *resources) consulClient(agent string) (*api.Client, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if c := r.consulClients[agent]; c != nil {
+		return c, nil
+	}
+	c, err := api.NewClient(&api.Config{
+		Address: agent,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.consulClients[agent] = c
+	return c, nil
+}
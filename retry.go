@@ -0,0 +1,179 @@
+package httplb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	defaultRetryBudgetWindow = 10 * time.Second
+
+	// retryBudgetBuckets 重试预算滚动窗口的分段数
+	retryBudgetBuckets = 10
+)
+
+type nonIdempotentRetryKey struct{}
+
+// WithNonIdempotentRetry 返回允许重试非幂等请求（如POST）的ctx，用于DoContext，
+// 仅在调用方确认请求可以重复发送时使用
+func WithNonIdempotentRetry(ctx context.Context) context.Context {
+	return context.WithValue(ctx, nonIdempotentRetryKey{}, true)
+}
+
+// retryBudget 重试预算，限制滚动窗口内的重试次数不超过请求数×ratio+minPerSecond×窗口秒数，
+// 避免节点故障时重试放大请求量。对冲请求使用单独的预算
+type retryBudget struct {
+	lock    sync.Mutex
+	buckets [retryBudgetBuckets]retryBucket
+}
+
+// requestBudgets 负载均衡器的重试及对冲请求预算，切换负载均衡策略时由新的负载均衡器沿用
+type requestBudgets struct {
+	retry   retryBudget    // 重试预算
+	hedge   retryBudget    // 对冲请求的预算
+	latency latencyTracker // 对冲请求的耗时统计
+}
+
+// retryBucket 滚动窗口中一个分段内的请求数及重试次数
+type retryBucket struct {
+	epoch    int64
+	requests int
+	retries  int
+}
+
+func (b *retryBudget) bucket(now time.Time) (*retryBucket, int64) {
+	epoch := now.UnixNano() / int64(defaultRetryBudgetWindow/retryBudgetBuckets)
+	bucket := &b.buckets[epoch%retryBudgetBuckets]
+	if bucket.epoch != epoch {
+		*bucket = retryBucket{epoch: epoch}
+	}
+	return bucket, epoch
+}
+
+// deposit 记录一次请求
+func (b *retryBudget) deposit() {
+	b.lock.Lock()
+	bucket, _ := b.bucket(time.Now())
+	bucket.requests++
+	b.lock.Unlock()
+}
+
+// withdraw 预算充足时记录一次重试并返回true
+func (b *retryBudget) withdraw(ratio float64, minPerSecond int) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	bucket, epoch := b.bucket(time.Now())
+	var requests, retries int
+	for _, bk := range b.buckets {
+		if epoch-bk.epoch < retryBudgetBuckets {
+			requests += bk.requests
+			retries += bk.retries
+		}
+	}
+	limit := float64(requests)*ratio + float64(minPerSecond)*defaultRetryBudgetWindow.Seconds()
+	if float64(retries+1) > limit {
+		return false
+	}
+	bucket.retries++
+	return true
+}
+
+// doWithRetry 在lb上发送请求，失败时按config在其他节点上重试
+//
+// 每次重试选取尚未尝试过的节点，无法选出新节点（如一致性哈希策略）、超出重试预算或达到MaxRetries时返回最后一次的结果
+func doWithRetry(lb *balancer, config *RetryConfig, ctx context.Context,
+	req *fasthttp.Request, resp *fasthttp.Response, send func(c *lbClient) error) error {
+	if lb.isClosed() {
+		return ErrLBClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	budget := &lb.requestBudgets().retry
+	budget.deposit()
+
+	c := lb.pick(req)
+	if c == nil {
+		return ErrNoNodes
+	}
+	err := send(c)
+	if !shouldRetry(config, ctx, req, resp, err) {
+		return err
+	}
+	tried := []Client{c.c}
+	for i, resent := 0, 0; i < config.MaxRetries; {
+		c = pickUntried(lb, req, tried)
+		if c == nil {
+			break
+		}
+		if err == errClientClosed && resent < maxPickAttempts {
+			// Client已关闭时请求未发出，重新发送不计入重试次数及预算
+			resent++
+		} else {
+			if !budget.withdraw(config.BudgetRatio, config.BudgetMinPerSecond) {
+				break
+			}
+			i++
+		}
+		tried = append(tried, c.c)
+		if err = send(c); !shouldRetry(config, ctx, req, resp, err) {
+			return err
+		}
+	}
+	return err
+}
+
+// pickUntried 选取不在tried中的节点，多次选取均已尝试过时返回nil
+func pickUntried(lb *balancer, req *fasthttp.Request, tried []Client) *lbClient {
+	for i := 0; i < maxPickAttempts+len(tried); i++ {
+		c := lb.pick(req)
+		if c != nil && !containsClient(tried, c.c) {
+			return c
+		}
+	}
+	return nil
+}
+
+func containsClient(clients []Client, c Client) bool {
+	for _, v := range clients {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRetry 判断请求结果是否需要重试
+//
+// 连接错误、超时、断路器打开及RetryStatuses中的状态码可以重试，非幂等请求仅在允许时重试；
+// Client已关闭时请求未发出，任何请求都换一个节点重试
+func shouldRetry(config *RetryConfig, ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response, err error) bool {
+	if err == ErrLBClosed || ctx.Err() != nil {
+		return false
+	}
+	if err == errClientClosed {
+		return true
+	}
+	if err == nil && !expectedStatus(config.RetryStatuses, resp.StatusCode()) {
+		return false
+	}
+	if isIdempotent(req) || config.RetryNonIdempotent {
+		return true
+	}
+	allowed, _ := ctx.Value(nonIdempotentRetryKey{}).(bool)
+	return allowed
+}
+
+// isIdempotent 判断请求方法是否幂等，见RFC 7231 4.2.2
+func isIdempotent(req *fasthttp.Request) bool {
+	switch string(req.Header.Method()) {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodOptions, fasthttp.MethodTrace,
+		fasthttp.MethodPut, fasthttp.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
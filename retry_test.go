@@ -0,0 +1,133 @@
+package httplb_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+// closedAddr 返回一个没有监听的地址，连接时立即失败
+func closedAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return addr
+}
+
+func TestRetryOnDifferentNode(t *testing.T) {
+	addr, stop := startServer(t, okHandler)
+	defer stop()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, closedAddr(t), closedAddr(t), addr)
+	cfg.Retry = &httplb.RetryConfig{MaxRetries: 2}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + addr + "/")
+
+	// 每次重试都选择未尝试过的节点，最多3次尝试必然到达可用节点
+	for i := 0; i < 9; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatalf("GET request %d: %v", i, err)
+		}
+	}
+
+	// 非幂等请求默认不重试
+	req.Header.SetMethod(fasthttp.MethodPost)
+	failed := 0
+	for i := 0; i < 9; i++ {
+		if lb.Do(req, resp) != nil {
+			failed++
+		}
+	}
+	if failed == 0 {
+		t.Fatal("expected POST requests to fail without retry")
+	}
+	ctx := httplb.WithNonIdempotentRetry(context.Background())
+	for i := 0; i < 9; i++ {
+		if err := lb.DoContext(ctx, req, resp); err != nil {
+			t.Fatalf("POST request %d with retry allowed: %v", i, err)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	var hits int32
+	failHandler := func(ctx *fasthttp.RequestCtx) {
+		atomic.AddInt32(&hits, 1)
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	}
+	addrA, stopA := startServer(t, failHandler)
+	defer stopA()
+	addrB, stopB := startServer(t, failHandler)
+	defer stopB()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addrA, addrB)
+	cfg.Retry = &httplb.RetryConfig{MaxRetries: 1, BudgetRatio: 0.1, BudgetMinPerSecond: 1}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + addrA + "/")
+
+	// 预算为请求数×0.1+1×10，100个请求最多重试20次
+	const requests = 100
+	for i := 0; i < requests; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode() != fasthttp.StatusServiceUnavailable {
+			t.Fatalf("unexpected status %d", resp.StatusCode())
+		}
+	}
+	if retries := atomic.LoadInt32(&hits) - requests; retries > 20 || retries < 10 {
+		t.Fatalf("expected retries to be capped by the budget, got %d", retries)
+	}
+}
+
+// 直接创建的各策略负载均衡器同样按Config.Retry重试
+func TestRetryDirectConstructor(t *testing.T) {
+	addr, stop := startServer(t, okHandler)
+	defer stop()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, closedAddr(t), addr)
+	cfg.Retry = &httplb.RetryConfig{MaxRetries: 1}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.NewRoundRobinLB(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + addr + "/")
+
+	for i := 0; i < 4; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+}
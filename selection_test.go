@@ -0,0 +1,65 @@
+package httplb_test
+
+import (
+	"fmt"
+	"testing"
+
+	httplb "http-loadbalance"
+)
+
+var selectionStrategies = []struct {
+	name     string
+	strategy httplb.LBStrategy
+}{
+	{"RoundRobin", httplb.LBRoundRobin},
+	{"Random", httplb.LBRandom},
+	{"WeightedRoundRobin", httplb.LBWeightedRoundRobin},
+	{"LeastConnection", httplb.LBLeastConnection},
+	{"ConsistentHash", httplb.LBConsistentHash},
+	{"Maglev", httplb.LBMaglev},
+	{"PowerOfTwo", httplb.LBPowerOfTwo},
+	{"PeakEWMA", httplb.LBPeakEWMA},
+	{"WeightedRandom", httplb.LBWeightedRandom},
+	{"WeightedLeastConnection", httplb.LBWeightedLeastConnection},
+}
+
+func newSelectionLB(tb testing.TB, strategy httplb.LBStrategy, n int) httplb.LoadBalancer {
+	var ipList []string
+	for i := 1; i <= n; i++ {
+		ipList = append(ipList, fmt.Sprintf("10.0.0.%d:8080 weight=%d", i, i%5+1))
+	}
+	cfg := newStaticConfig(strategy, ipList...)
+	if err := cfg.Validate(); err != nil {
+		tb.Fatal(err)
+	}
+	return httplb.New(cfg)
+}
+
+// 选取节点时不分配内存
+func TestGetZeroAlloc(t *testing.T) {
+	for _, s := range selectionStrategies {
+		lb := newSelectionLB(t, s.strategy, 10)
+		if allocs := testing.AllocsPerRun(1000, func() { lb.Get() }); allocs != 0 {
+			t.Errorf("%s: Get allocates %v times per call", s.name, allocs)
+		}
+		lb.Close()
+	}
+}
+
+// go test -run ^$ -bench BenchmarkGet -cpu 1,8,64
+func BenchmarkGet(b *testing.B) {
+	for _, s := range selectionStrategies {
+		b.Run(s.name, func(b *testing.B) {
+			lb := newSelectionLB(b, s.strategy, 10)
+			defer lb.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					lb.Get()
+				}
+			})
+		})
+	}
+}
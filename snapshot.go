@@ -0,0 +1,55 @@
+package httplb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// clientList 可选取节点列表的不可变快照
+//
+// init时创建新的列表并通过atomic.Value整体替换，发布后不再修改，
+// 选取节点时只需一次原子读取，无需加锁且不分配内存。
+// 列表与已按其更新的picker及当时的配置一同发布，切换负载均衡策略时选取节点总能看到一致的组合
+type clientList struct {
+	v atomic.Value // *snapshot
+}
+
+type snapshot struct {
+	cs     []*lbClient
+	picker picker
+	config *Config
+}
+
+func (l *clientList) store(cs []*lbClient, p picker, config *Config) {
+	l.v.Store(&snapshot{cs: cs, picker: p, config: config})
+}
+
+func (l *clientList) load() []*lbClient {
+	if s := l.snapshot(); s != nil {
+		return s.cs
+	}
+	return nil
+}
+
+func (l *clientList) snapshot() *snapshot {
+	s, _ := l.v.Load().(*snapshot)
+	return s
+}
+
+// randState fastrand的状态，每次调用原子递增
+var randState = uint64(time.Now().UnixNano())
+
+// fastrand 无锁的伪随机数（splitmix64），可并发调用，用于随机选取节点
+//
+// math/rand的全局函数内部加锁，*rand.Rand不能并发使用，均不适合选取节点的热路径
+func fastrand() uint64 {
+	x := atomic.AddUint64(&randState, 0x9e3779b97f4a7c15)
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// fastrandn 返回[0, n)内的伪随机数
+func fastrandn(n int) int {
+	return int(fastrand() % uint64(n))
+}
@@ -0,0 +1,130 @@
+package httplb
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// LBStrategy 负载均衡策略的ID，内置策略见LBRoundRobin等常量，自定义策略的ID由RegisterStrategy分配
+//
+// 实现了encoding.TextUnmarshaler，配置文件中可使用策略名称（如"p2c"）或ID
+type LBStrategy int
+
+// String 返回已注册策略的名称，未注册时返回ID
+func (s LBStrategy) String() string {
+	if st, ok := lookupStrategy(s); ok {
+		return st.name
+	}
+	return strconv.Itoa(int(s))
+}
+
+// UnmarshalText 按策略名称或ID解析，名称未注册时返回错误
+func (s *LBStrategy) UnmarshalText(text []byte) error {
+	if st, ok := lookupStrategyName(string(text)); ok {
+		*s = st.id
+		return nil
+	}
+	id, err := strconv.Atoi(string(text))
+	if err != nil {
+		return fmt.Errorf("httplb: unknown lb_strategy %q", text)
+	}
+	*s = LBStrategy(id)
+	return nil
+}
+
+// strategy 可通过Config.LBStrategy选择的负载均衡策略
+type strategy struct {
+	id   LBStrategy
+	name string
+	// newLB 创建负载均衡器，core为切换负载均衡策略前的balancer，新策略的负载均衡器沿用其节点及各节点的状态
+	newLB func(config *Config, core *balancer) reloadableLB
+}
+
+// customStrategyBase RegisterStrategy注册的策略从该ID开始分配，与内置策略的ID区分
+const customStrategyBase = 1000
+
+var (
+	strategyLock   sync.RWMutex
+	strategies     = make(map[LBStrategy]*strategy)
+	strategyIDs    = make(map[string]LBStrategy) // 策略名称到ID的映射
+	nextStrategyID = LBStrategy(customStrategyBase)
+)
+
+// registerStrategy 注册负载均衡策略，New()及UpdateConfig根据Config.LBStrategy创建对应的负载均衡器
+//
+// 重复注册相同的ID或名称时panic
+func registerStrategy(id LBStrategy, name string, newLB func(config *Config, core *balancer) reloadableLB) {
+	strategyLock.Lock()
+	defer strategyLock.Unlock()
+	if _, ok := strategies[id]; ok {
+		panic("httplb: strategy registered twice: " + name)
+	}
+	if _, ok := strategyIDs[name]; ok {
+		panic("httplb: strategy registered twice: " + name)
+	}
+	strategies[id] = &strategy{id: id, name: name, newLB: newLB}
+	strategyIDs[name] = id
+}
+
+// registerCustomStrategy 为自定义策略分配ID并注册
+func registerCustomStrategy(name string, newLB func(config *Config, core *balancer) reloadableLB) LBStrategy {
+	strategyLock.Lock()
+	id := nextStrategyID
+	nextStrategyID++
+	strategyLock.Unlock()
+	registerStrategy(id, name, newLB)
+	return id
+}
+
+// lookupStrategy 获取已注册的负载均衡策略
+func lookupStrategy(id LBStrategy) (*strategy, bool) {
+	strategyLock.RLock()
+	defer strategyLock.RUnlock()
+	s, ok := strategies[id]
+	return s, ok
+}
+
+// lookupStrategyName 根据名称获取已注册的负载均衡策略
+func lookupStrategyName(name string) (*strategy, bool) {
+	strategyLock.RLock()
+	defer strategyLock.RUnlock()
+	id, ok := strategyIDs[name]
+	if !ok {
+		return nil, false
+	}
+	return strategies[id], true
+}
+
+func init() {
+	registerStrategy(LBRoundRobin, "round_robin", func(config *Config, core *balancer) reloadableLB {
+		return newRoundRobinLB(config, core)
+	})
+	registerStrategy(LBRandom, "random", func(config *Config, core *balancer) reloadableLB {
+		return newRandomLB(config, core)
+	})
+	registerStrategy(LBWeightedRoundRobin, "weighted_round_robin", func(config *Config, core *balancer) reloadableLB {
+		return newWeightedRoundRobinLB(config, core)
+	})
+	registerStrategy(LBLeastConnection, "least_connection", func(config *Config, core *balancer) reloadableLB {
+		return newLeastLB(config, core)
+	})
+	registerStrategy(LBConsistentHash, "consistent_hash", func(config *Config, core *balancer) reloadableLB {
+		return newConsistentHashLB(config, core)
+	})
+	registerStrategy(LBMaglev, "maglev", func(config *Config, core *balancer) reloadableLB {
+		return newMaglevLB(config, core)
+	})
+	registerStrategy(LBPowerOfTwo, "p2c", func(config *Config, core *balancer) reloadableLB {
+		return newPowerOfTwoLB(config, core)
+	})
+	registerStrategy(LBPeakEWMA, "peak_ewma", func(config *Config, core *balancer) reloadableLB {
+		return newPeakEWMALB(config, core)
+	})
+	registerStrategy(LBWeightedRandom, "weighted_random", func(config *Config, core *balancer) reloadableLB {
+		return newWeightedRandomLB(config, core)
+	})
+	registerStrategy(LBWeightedLeastConnection, "weighted_least_connection", func(config *Config, core *balancer) reloadableLB {
+		return newWeightedLeastConnectionLB(config, core)
+	})
+}
@@ -0,0 +1,88 @@
+package httplb
+
+import "sync/atomic"
+
+// maxWRRSchedule 平滑加权轮询选择序列的最大长度，权重之和（约去最大公约数后）超过该值时按比例缩小各节点的权重
+const maxWRRSchedule = 4096
+
+// smoothWRR nginx平滑加权轮询
+//
+// 每次选择时各节点的当前权重加上其权重，选择当前权重最大的节点，并将其当前权重减去所有节点的权重之和。
+// 如权重为5、1、1的节点a、b、c，选择顺序为a a b a c a a，而不是a a a a a b c。
+// 选择序列以权重之和为周期，因此创建时预先计算一个周期的选择序列，选择时原子递增下标，无需加锁。
+// 节点列表变化时重新创建，节点的权重立即生效
+type smoothWRR struct {
+	cs       []*lbClient
+	schedule []int32 // 一个周期内依次选择的节点下标
+	index    uint64  // 下一次选择在schedule中的位置
+}
+
+func newSmoothWRR(cs []*lbClient) *smoothWRR {
+	weights := make([]int64, len(cs))
+	for i, c := range cs {
+		weights[i] = int64(nodeWeight(c.Node()))
+	}
+	return &smoothWRR{cs: cs, schedule: wrrSchedule(weights)}
+}
+
+// wrrSchedule 按nginx平滑加权轮询计算一个周期的选择序列
+func wrrSchedule(weights []int64) []int32 {
+	total := reduceWeights(weights)
+	if total > maxWRRSchedule && total > int64(len(weights)) {
+		// 按比例缩小，每个节点至少保留1，保证仍会被选择
+		limit := int64(maxWRRSchedule)
+		if limit < int64(len(weights)) {
+			limit = int64(len(weights))
+		}
+		for i, w := range weights {
+			if weights[i] = w * limit / total; weights[i] == 0 {
+				weights[i] = 1
+			}
+		}
+		total = reduceWeights(weights)
+	}
+
+	schedule := make([]int32, 0, total)
+	current := make([]int64, len(weights))
+	for int64(len(schedule)) < total {
+		best := 0
+		for i := range current {
+			current[i] += weights[i]
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		schedule = append(schedule, int32(best))
+	}
+	return schedule
+}
+
+// reduceWeights 各权重除以其最大公约数，返回约分后的权重之和
+func reduceWeights(weights []int64) int64 {
+	var g int64
+	for _, w := range weights {
+		g = gcd(g, w)
+	}
+	var total int64
+	for i := range weights {
+		if g > 1 {
+			weights[i] /= g
+		}
+		total += weights[i]
+	}
+	return total
+}
+
+func gcd(a, b int64) int64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// next 获取下一个节点
+func (w *smoothWRR) next() *lbClient {
+	i := atomic.AddUint64(&w.index, 1) - 1
+	return w.cs[w.schedule[i%uint64(len(w.schedule))]]
+}
@@ -0,0 +1,157 @@
+package httplb
+
+import (
+	"context"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// TimeoutHeader 通过请求头指定本次请求的超时时间，值为time.ParseDuration格式，如"500ms"。
+// 用于Do及DoContext，请求发送前该请求头会被移除
+const TimeoutHeader = "X-Httplb-Timeout"
+
+var (
+	// ErrSelectionTimeout Do/DoContext在超时时间内未能选出节点并发出请求
+	ErrSelectionTimeout error = &lbTimeoutError{"httplb: timed out before the request was sent to a node"}
+
+	// ErrUpstreamTimeout Do/DoContext的请求已发送到节点，但节点未在超时时间内返回响应
+	ErrUpstreamTimeout error = &lbTimeoutError{"httplb: timed out waiting for the upstream response"}
+)
+
+// lbTimeoutError 超时错误，errors.Is(err, fasthttp.ErrTimeout)仍然成立
+type lbTimeoutError struct {
+	msg string
+}
+
+func (e *lbTimeoutError) Error() string {
+	return e.msg
+}
+
+// Timeout 实现net.Error
+func (e *lbTimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary 实现net.Error
+func (e *lbTimeoutError) Temporary() bool {
+	return true
+}
+
+func (e *lbTimeoutError) Is(target error) bool {
+	return target == fasthttp.ErrTimeout
+}
+
+type requestTimeoutKey struct{}
+
+// WithRequestTimeout 返回指定本次请求超时时间的ctx，用于DoContext，优先于TimeoutHeader及Opts.RequestTimeout
+func WithRequestTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, requestTimeoutKey{}, timeout)
+}
+
+// requestTimeout 获取Do的默认超时时间，依次为Opts.RequestTimeout、负载均衡器的Timeout字段及DefaultLBClientTimeout
+func requestTimeout(opts *Opts, timeout time.Duration) time.Duration {
+	if opts != nil && opts.RequestTimeout > 0 {
+		return opts.RequestTimeout
+	}
+	if timeout > 0 {
+		return timeout
+	}
+	return DefaultLBClientTimeout
+}
+
+// headerTimeout 获取并移除请求头中的超时时间
+func headerTimeout(req *fasthttp.Request) (time.Duration, bool) {
+	v := req.Header.Peek(TimeoutHeader)
+	if len(v) == 0 {
+		return 0, false
+	}
+	timeout, err := time.ParseDuration(string(v))
+	req.Header.Del(TimeoutHeader)
+	if err != nil {
+		return 0, false
+	}
+	return timeout, true
+}
+
+// doTimeout 按请求头或默认的超时时间选取节点并发送请求
+func doTimeout(req *fasthttp.Request, resp *fasthttp.Response,
+	timeout func() time.Duration, pick func(req *fasthttp.Request) *lbClient) error {
+	d, ok := headerTimeout(req)
+	if !ok {
+		d = timeout()
+	}
+	deadline := time.Now().Add(d)
+	if !time.Now().Before(deadline) {
+		return ErrSelectionTimeout
+	}
+	return upstreamTimeout(sendPicked(req, pick, func(c *lbClient) error {
+		if !time.Now().Before(deadline) {
+			return ErrSelectionTimeout
+		}
+		return c.DoDeadline(req, resp, deadline)
+	}))
+}
+
+// upstreamTimeout 将请求发出后的超时错误转换为ErrUpstreamTimeout
+func upstreamTimeout(err error) error {
+	if err == fasthttp.ErrTimeout || err == context.DeadlineExceeded {
+		return ErrUpstreamTimeout
+	}
+	return err
+}
+
+// withRequestTimeout 为DoContext的ctx设置超时时间
+//
+// 依次取WithRequestTimeout、TimeoutHeader指定的超时时间；都未指定时，ctx没有截止时间则使用默认的超时时间，
+// 否则直接使用ctx。返回值applied表示是否设置了超时时间
+func withRequestTimeout(ctx context.Context, req *fasthttp.Request,
+	timeout func() time.Duration) (_ context.Context, cancel context.CancelFunc, applied bool) {
+	d, ok := headerTimeout(req)
+	if v, vok := ctx.Value(requestTimeoutKey{}).(time.Duration); vok {
+		d, ok = v, true
+	}
+	if !ok {
+		if _, hasDeadline := ctx.Deadline(); hasDeadline {
+			return ctx, func() {}, false
+		}
+		d = timeout()
+	}
+	ctx, cancel = context.WithTimeout(ctx, d)
+	return ctx, cancel, true
+}
+
+// contextTimeout 将withRequestTimeout设置的超时时间到达导致的错误转换为ErrSelectionTimeout或ErrUpstreamTimeout，
+// 调用方ctx自身的截止时间及取消不做转换
+func contextTimeout(parent context.Context, sent bool, err error) error {
+	if err != context.DeadlineExceeded || parent.Err() != nil {
+		return err
+	}
+	if sent {
+		return ErrUpstreamTimeout
+	}
+	return ErrSelectionTimeout
+}
+
+// doContextTimeout 在ctx及请求超时时间的控制下选取节点并发送请求
+func doContextTimeout(ctx context.Context, req *fasthttp.Request, resp *fasthttp.Response,
+	timeout func() time.Duration, pick func(req *fasthttp.Request) *lbClient) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	reqCtx, cancel, applied := withRequestTimeout(ctx, req, timeout)
+	defer cancel()
+	if err := reqCtx.Err(); err != nil {
+		return contextTimeout(ctx, false, err)
+	}
+	err := sendPicked(req, pick, func(c *lbClient) error {
+		if err := reqCtx.Err(); err != nil {
+			return contextTimeout(ctx, false, err)
+		}
+		return c.DoContext(reqCtx, req, resp)
+	})
+	if applied {
+		err = contextTimeout(ctx, true, err)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package httplb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	addr, stop := startServer(t, func(ctx *fasthttp.RequestCtx) {
+		if len(ctx.Request.Header.Peek(httplb.TimeoutHeader)) != 0 {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	})
+	defer stop()
+
+	cfg := newStaticConfig(httplb.LBRoundRobin, addr)
+	cfg.Opts.MaxConns = 16
+	cfg.Opts.RequestTimeout = 50 * time.Millisecond
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + addr + "/")
+
+	// Opts.RequestTimeout
+	start := time.Now()
+	err := lb.Do(req, resp)
+	if err != httplb.ErrUpstreamTimeout {
+		t.Fatalf("expected ErrUpstreamTimeout, got %v", err)
+	}
+	if !errors.Is(err, fasthttp.ErrTimeout) {
+		t.Fatal("expected ErrUpstreamTimeout to match fasthttp.ErrTimeout")
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("Do took %s, timeout was not applied", elapsed)
+	}
+
+	// 请求头指定的超时时间优先，且不会发送给节点
+	req.Header.Set(httplb.TimeoutHeader, "1s")
+	if err = lb.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("timeout header was forwarded, status %d", resp.StatusCode())
+	}
+
+	// 超时时间在选取节点前已到达
+	req.Header.Set(httplb.TimeoutHeader, "0s")
+	if err = lb.Do(req, resp); err != httplb.ErrSelectionTimeout {
+		t.Fatalf("expected ErrSelectionTimeout, got %v", err)
+	}
+
+	// ctx中指定的超时时间
+	ctx := httplb.WithRequestTimeout(context.Background(), time.Second)
+	if err = lb.DoContext(ctx, req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if err = lb.DoContext(context.Background(), req, resp); err != httplb.ErrUpstreamTimeout {
+		t.Fatalf("expected ErrUpstreamTimeout from DoContext, got %v", err)
+	}
+}
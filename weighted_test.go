@@ -0,0 +1,68 @@
+package httplb_test
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+
+	httplb "http-loadbalance"
+)
+
+func TestWeightedRandom(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBWeightedRandom,
+		"10.0.0.1:8080 weight=1", "10.0.0.2:8080 weight=3", "10.0.0.3:8080 weight=6")
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	const requests = 20000
+	counts := make(map[string]int)
+	for i := 0; i < requests; i++ {
+		counts[lb.Get().Node().Addr()]++
+	}
+	expected := map[string]int{"10.0.0.1:8080": requests / 10, "10.0.0.2:8080": requests * 3 / 10, "10.0.0.3:8080": requests * 6 / 10}
+	for addr, want := range expected {
+		if n := counts[addr]; n < want*8/10 || n > want*12/10 {
+			t.Fatalf("node %s got %d of %d requests, expected about %d", addr, n, requests, want)
+		}
+	}
+}
+
+func TestWeightedLeastConnection(t *testing.T) {
+	addrA, stopA := startServer(t, okHandler)
+	defer stopA()
+	addrB, stopB := startServer(t, okHandler)
+	defer stopB()
+
+	cfg := newStaticConfig(httplb.LBWeightedLeastConnection, addrA+" weight=1", addrB+" weight=3")
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI("http://" + addrA + "/")
+
+	// 请求串行发送时进行中的请求数均为0，按已处理请求数/权重分配
+	const requests = 40
+	for i := 0; i < requests; i++ {
+		if err := lb.Do(req, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range lb.Stats() {
+		want := uint64(requests / 4)
+		if s.Node.Addr() == addrB {
+			want = requests * 3 / 4
+		}
+		if s.Total != want {
+			t.Fatalf("node %s handled %d requests, expected %d", s.Node.Addr(), s.Total, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package httplb
+
+import (
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// WeightedLeastConnectionLB 加权最小连接数
+//
+// 选择进行中的请求数与Node.Weight之比最小的节点，权重越大的节点承担越多的并发请求
+type WeightedLeastConnectionLB struct {
+	*balancer
+}
+
+// NewWeightedLeastConnectionLB 创建加权最小连接数负载均衡策略
+func NewWeightedLeastConnectionLB(config *Config) *WeightedLeastConnectionLB {
+	return newWeightedLeastConnectionLB(config, nil)
+}
+
+// newWeightedLeastConnectionLB 创建负载均衡器，core为切换负载均衡策略前的balancer，为nil时创建新的balancer
+func newWeightedLeastConnectionLB(config *Config, core *balancer) *WeightedLeastConnectionLB {
+	lb := &WeightedLeastConnectionLB{}
+	lb.balancer = newBalancer(lb, config, core)
+	return lb
+}
+
+func (cc *WeightedLeastConnectionLB) update(cs []*lbClient, config *Config) {}
+
+func (cc *WeightedLeastConnectionLB) choose(cs []*lbClient, req *fasthttp.Request) *lbClient {
+	// 比较n/w时交叉相乘，避免浮点运算
+	minC := cs[0]
+	minN := uint64(minC.PendingRequests())
+	minT := atomic.LoadUint64(&minC.total)
+	minW := uint64(nodeWeight(minC.Node()))
+	for _, c := range cs[1:] {
+		n := uint64(c.PendingRequests())
+		t := atomic.LoadUint64(&c.total)
+		w := uint64(nodeWeight(c.Node()))
+		if n*minW < minN*w || (n*minW == minN*w && t*minW < minT*w) {
+			minC = c
+			minN = n
+			minT = t
+			minW = w
+		}
+	}
+	return minC
+}
@@ -0,0 +1,62 @@
+package httplb
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// WeightedRandomLB 加权随机
+//
+// 各节点被选中的概率与Node.Weight成正比，节点变化时重建累计权重，选择时二分查找，时间复杂度为O(logN)
+type WeightedRandomLB struct {
+	*balancer
+
+	weights atomic.Value // *cumulativeWeights
+}
+
+// NewWeightedRandomLB 创建加权随机负载均衡策略
+func NewWeightedRandomLB(config *Config) *WeightedRandomLB {
+	return newWeightedRandomLB(config, nil)
+}
+
+// newWeightedRandomLB 创建负载均衡器，core为切换负载均衡策略前的balancer，为nil时创建新的balancer
+func newWeightedRandomLB(config *Config, core *balancer) *WeightedRandomLB {
+	lb := &WeightedRandomLB{}
+	lb.balancer = newBalancer(lb, config, core)
+	return lb
+}
+
+func (cc *WeightedRandomLB) update(cs []*lbClient, config *Config) {
+	cc.weights.Store(newCumulativeWeights(cs))
+}
+
+func (cc *WeightedRandomLB) choose(cs []*lbClient, req *fasthttp.Request) *lbClient {
+	weights := cc.weights.Load().(*cumulativeWeights)
+	return weights.pick(int64(fastrand() % uint64(weights.total)))
+}
+
+// cumulativeWeights 各节点的累计权重
+type cumulativeWeights struct {
+	cs    []*lbClient
+	sums  []int64 // sums[i]为前i+1个节点的权重之和
+	total int64
+}
+
+func newCumulativeWeights(cs []*lbClient) *cumulativeWeights {
+	w := &cumulativeWeights{cs: cs, sums: make([]int64, len(cs))}
+	for i, c := range cs {
+		w.total += int64(nodeWeight(c.Node()))
+		w.sums[i] = w.total
+	}
+	return w
+}
+
+// pick 获取累计权重大于n的第一个节点，n的取值范围为[0, total)
+func (w *cumulativeWeights) pick(n int64) *lbClient {
+	i := sort.Search(len(w.sums), func(i int) bool {
+		return w.sums[i] > n
+	})
+	return w.cs[i]
+}
@@ -0,0 +1,154 @@
+package httplb_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	httplb "http-loadbalance"
+)
+
+func TestSmoothWeightedRoundRobin(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBWeightedRoundRobin,
+		"10.0.0.1:8080 weight=5", "10.0.0.2:8080 weight=1", "10.0.0.3:8080 weight=1")
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	names := map[string]string{"10.0.0.1:8080": "a", "10.0.0.2:8080": "b", "10.0.0.3:8080": "c"}
+	order := func() string {
+		var seq []string
+		for i := 0; i < 7; i++ {
+			seq = append(seq, names[lb.Get().Node().Addr()])
+		}
+		return strings.Join(seq, " ")
+	}
+	if seq := order(); seq != "a a b a c a a" {
+		t.Fatalf("unexpected order %q", seq)
+	}
+
+	// 权重变化后立即按新的权重分配
+	names["10.0.0.4:8080"] = "d"
+	if err := lb.UpdateConfig(newStaticConfig(httplb.LBWeightedRoundRobin,
+		"10.0.0.1:8080 weight=1", "10.0.0.4:8080 weight=3")); err != nil {
+		t.Fatal(err)
+	}
+	if seq := order(); seq != "d a d d d a d" {
+		t.Fatalf("unexpected order after update %q", seq)
+	}
+}
+
+func TestSmoothWeightedRoundRobinConcurrent(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBWeightedRoundRobin,
+		"10.0.0.1:8080 weight=3", "10.0.0.2:8080 weight=2", "10.0.0.3:8080 weight=1")
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+
+	const goroutines, perGoroutine = 8, 600
+	var (
+		mu     sync.Mutex
+		counts = make(map[string]int)
+		wg     sync.WaitGroup
+	)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[string]int)
+			for i := 0; i < perGoroutine; i++ {
+				local[lb.Get().Node().Addr()]++
+			}
+			mu.Lock()
+			for addr, n := range local {
+				counts[addr] += n
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// 总请求数为权重之和的整数倍时，各节点的请求数与权重严格成比例
+	total := goroutines * perGoroutine
+	expected := map[string]int{"10.0.0.1:8080": total / 2, "10.0.0.2:8080": total / 3, "10.0.0.3:8080": total / 6}
+	for addr, want := range expected {
+		if counts[addr] != want {
+			t.Fatalf("node %s got %d requests, expected %d", addr, counts[addr], want)
+		}
+	}
+}
+
+// 权重约分后计算选择序列，权重之和较大时按比例缩小，权重较小的节点仍会被选择
+func TestSmoothWeightedRoundRobinLargeWeights(t *testing.T) {
+	cfg := newStaticConfig(httplb.LBWeightedRoundRobin, "10.0.0.1:8080 weight=200", "10.0.0.2:8080 weight=100")
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	lb := httplb.New(cfg)
+	defer lb.Close()
+	var seq []string
+	for i := 0; i < 3; i++ {
+		seq = append(seq, lb.Get().Node().Addr())
+	}
+	if got := strings.Join(seq, " "); got != "10.0.0.1:8080 10.0.0.2:8080 10.0.0.1:8080" {
+		t.Fatalf("unexpected order %q", got)
+	}
+
+	if err := lb.UpdateConfig(newStaticConfig(httplb.LBWeightedRoundRobin,
+		"10.0.0.1:8080 weight=65535", "10.0.0.2:8080 weight=1")); err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 2*65536; i++ {
+		counts[lb.Get().Node().Addr()]++
+	}
+	if n := counts["10.0.0.2:8080"]; n == 0 || n > 64 {
+		t.Fatalf("light node got %d of %d requests", n, 2*65536)
+	}
+}
+
+// lockedSWRR 加锁实现的nginx平滑加权轮询，即每次选择时遍历各节点更新当前权重，作为BenchmarkGet/WeightedRoundRobin的对照
+type lockedSWRR struct {
+	mu      sync.Mutex
+	weights []int
+	current []int
+	total   int
+}
+
+func (w *lockedSWRR) next() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	best := 0
+	for i := range w.current {
+		w.current[i] += w.weights[i]
+		if w.current[i] > w.current[best] {
+			best = i
+		}
+	}
+	w.current[best] -= w.total
+	return best
+}
+
+// BenchmarkWeightedRoundRobinLocked 与BenchmarkGet/WeightedRoundRobin使用相同的节点权重，对比加锁逐次计算与预先计算选择序列的开销：
+//
+//	go test -run ^$ -bench 'BenchmarkGet/WeightedRoundRobin$|BenchmarkWeightedRoundRobinLocked' -cpu 1,8,64
+func BenchmarkWeightedRoundRobinLocked(b *testing.B) {
+	w := &lockedSWRR{}
+	for i := 1; i <= 10; i++ {
+		w.weights = append(w.weights, i%5+1)
+		w.total += i%5 + 1
+	}
+	w.current = make([]int, len(w.weights))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w.next()
+		}
+	})
+}